@@ -0,0 +1,406 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"gotrading/app/models"
+	"gotrading/bitflyer"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自動売買の設定と状態を保持する構造体を定義
+type AI struct {
+	API          *bitflyer.APIClient
+	ProductCode  string
+	CoinCode     string // ex) BTC_JPY => BTC
+	CurrencyCode string // ex) BTC_JPY => JPY
+	Duration     time.Duration
+	Strategy     models.Strategy
+	UsePercent   float64
+	DataLimit    int
+	SignalEvents *models.SignalEvents
+	BackTest     bool                // trueの場合は注文を送信せず、終値で約定したとみなしてメモリ上にのみ記録する
+	OrderBook    *bitflyer.OrderBook // 設定されている場合は成行注文の前にスリッページを見積もる
+	OrderEvents  *OrderEventTracker  // 設定されている場合は注文のイベントで約定を確認する
+
+	// 戦略のパラメータを最適化し直す間隔(0の場合は最適化しない)
+	OptimizeInterval time.Duration
+
+	lastOptimized time.Time
+	disabled      bool // 通貨とコインを判定できない銘柄の場合はCandleの取り込みのみ行い、売買しない
+	mu            sync.Mutex
+}
+
+// 自動売買を行うAIを生成するコンストラクタ
+func NewAI(api *bitflyer.APIClient, productCode string, duration time.Duration, strategy models.Strategy, usePercent float64, dataLimit int, backTest bool, optimizeInterval time.Duration) *AI {
+	coinCode, currencyCode, splitErr := splitProductCode(productCode)
+	if splitErr != nil {
+		log.Printf("action=NewAI product_code=%s err=%s", productCode, splitErr.Error())
+	}
+
+	var signalEvents *models.SignalEvents
+	if backTest {
+		// 保存済みのCandleで戦略を評価した結果を出力し、以降の売買はメモリ上でのみ記録する
+		signalEvents = models.NewSignalEvents()
+		result, err := models.RunBackTest(productCode, duration, dataLimit, strategy)
+		if err != nil {
+			log.Printf("action=NewAI err=%s", err.Error())
+		} else {
+			log.Printf("action=NewAI backtest strategy=%s profit=%f trades=%d win_rate=%f max_drawdown=%f sharpe_ratio=%f",
+				result.Strategy, result.Profit, result.Trades, result.WinRate, result.MaxDrawdown, result.SharpeRatio)
+		}
+	} else {
+		// 前回起動時までの売買の記録から、購入と売却のどちらが可能な状態かを復元する
+		var err error
+		signalEvents, err = models.GetSignalEventsByProductCode(productCode, 1)
+		if err != nil {
+			log.Printf("action=NewAI err=%s", err.Error())
+			signalEvents = models.NewSignalEvents()
+		}
+	}
+
+	return &AI{
+		API:              api,
+		ProductCode:      productCode,
+		CoinCode:         coinCode,
+		CurrencyCode:     currencyCode,
+		Duration:         duration,
+		Strategy:         strategy,
+		UsePercent:       usePercent,
+		DataLimit:        dataLimit,
+		SignalEvents:     signalEvents,
+		BackTest:         backTest,
+		OptimizeInterval: optimizeInterval,
+		disabled:         splitErr != nil,
+	}
+}
+
+// 銘柄をコインと通貨に分ける(ex: BTC_JPY => BTC, JPY FX_BTC_JPY => BTC, JPY)
+// 先物(BTCJPY28MAR2025など)のように"_"で区切られていない銘柄は分けられないためエラーを返す
+func splitProductCode(productCode string) (coinCode, currencyCode string, err error) {
+	codes := strings.Split(productCode, "_")
+	if len(codes) < 2 {
+		return "", "", fmt.Errorf("cannot split %s into coin and currency codes, trading is disabled", productCode)
+	}
+	return codes[len(codes)-2], codes[len(codes)-1], nil
+}
+
+// TradeDurationのCandleが新しく生成されるたびに呼び出される売買処理を定義
+// ctxがキャンセルされた場合は実行中のAPIリクエストと約定待ちを中断する
+func (ai *AI) Trade(ctx context.Context) {
+	// 前回の売買処理が終わっていない場合は今回の処理をスキップ
+	if !ai.mu.TryLock() {
+		log.Println("action=Trade status=skip reason=previous trade is running")
+		return
+	}
+	defer ai.mu.Unlock()
+	if ai.disabled {
+		return
+	}
+
+	df, err := models.GetAllCandle(ai.ProductCode, ai.Duration, ai.DataLimit)
+	if err != nil {
+		log.Printf("action=Trade err=%s", err.Error())
+		return
+	}
+	// 売買はtrade_durationの新しいCandleが始まった時に行うため、最後のCandleは始まったばかりの作成途中のCandleとなる
+	// バックテストと同じ判定になるよう、作成途中のCandleを除いて完成したCandleのみで判定する
+	lenCandles := len(df.Candles) - 1
+	if lenCandles <= 0 {
+		return
+	}
+	df.Candles = df.Candles[:lenCandles]
+
+	// OptimizeIntervalごとに、直近のCandleで戦略のパラメータを最適化し直す
+	if ai.OptimizeInterval > 0 && time.Since(ai.lastOptimized) >= ai.OptimizeInterval {
+		ai.optimize(df)
+	}
+
+	// 最新の完成したCandleに対する判定結果のみを使用する
+	signals := ai.Strategy.Signals(df)
+	signal := signals[lenCandles-1]
+	candle := df.Candles[lenCandles-1]
+	log.Printf("action=Trade strategy=%s signal=%s price=%f", ai.Strategy.Name(), signal, candle.Close)
+
+	if ai.BackTest {
+		switch signal {
+		case models.SignalBuy:
+			ai.SignalEvents.Buy(ai.ProductCode, candle.Time, candle.Close, models.BackTestSize, false)
+		case models.SignalSell:
+			ai.SignalEvents.Sell(ai.ProductCode, candle.Time, candle.Close, models.BackTestSize, false)
+		}
+		return
+	}
+
+	switch signal {
+	case models.SignalBuy:
+		if !ai.SignalEvents.CanBuy(candle.Time) {
+			return
+		}
+		price, size, ok := ai.Buy(ctx, candle.Close)
+		if !ok {
+			return
+		}
+		ai.SignalEvents.Buy(ai.ProductCode, candle.Time, price, size, true)
+	case models.SignalSell:
+		if !ai.SignalEvents.CanSell(candle.Time) {
+			return
+		}
+		price, size, ok := ai.Sell(ctx)
+		if !ok {
+			return
+		}
+		ai.SignalEvents.Sell(ai.ProductCode, candle.Time, price, size, true)
+	}
+}
+
+// 現在の戦略のパラメータをグリッドサーチで最適化し、最も損益が大きかったパラメータに切り替える処理を定義
+// 切り替えるかどうかはBackTestResult.ShouldReplaceで判定する
+func (ai *AI) optimize(df *models.DataFrameCandle) {
+	ai.lastOptimized = time.Now()
+	best, err := df.Optimize(ai.Strategy.Name())
+	if err != nil {
+		log.Printf("action=optimize err=%s", err.Error())
+		return
+	}
+	current := df.BackTest(ai.Strategy)
+	if !best.ShouldReplace(current) {
+		log.Printf("action=optimize status=keep params=%+v profit=%f trades=%d best_params=%+v best_profit=%f best_trades=%d",
+			current.Params, current.Profit, current.Trades, best.Params, best.Profit, best.Trades)
+		return
+	}
+	ai.Strategy = best.Params
+	log.Printf("action=optimize strategy=%s params=%+v profit=%f trades=%d win_rate=%f",
+		best.Strategy, best.Params, best.Profit, best.Trades, best.WinRate)
+}
+
+// 保有している通貨(JPY)のUsePercent分だけ成行で購入する処理を定義(約定価格と約定数量を返す)
+func (ai *AI) Buy(ctx context.Context, price float64) (executedPrice, executedSize float64, ok bool) {
+	currency, _ := ai.getAvailableBalance(ctx)
+	size := roundDownSize(currency * ai.UsePercent / price)
+	if size <= 0 {
+		log.Printf("action=Buy status=skip available=%f", currency)
+		return 0, 0, false
+	}
+	return ai.sendOrder(ctx, models.SignalBuy, size)
+}
+
+// 保有しているコイン(BTC)を全て成行で売却する処理を定義(約定価格と約定数量を返す)
+func (ai *AI) Sell(ctx context.Context) (executedPrice, executedSize float64, ok bool) {
+	_, coin := ai.getAvailableBalance(ctx)
+	size := roundDownSize(coin)
+	if size <= 0 {
+		log.Printf("action=Sell status=skip available=%f", coin)
+		return 0, 0, false
+	}
+	return ai.sendOrder(ctx, models.SignalSell, size)
+}
+
+// 成行注文を送信して約定するまで待つ処理を定義
+func (ai *AI) sendOrder(ctx context.Context, side string, size float64) (executedPrice, executedSize float64, ok bool) {
+	order := &bitflyer.Order{
+		ProductCode:     ai.ProductCode,
+		ChildOrderType:  "MARKET",
+		Side:            side,
+		Size:            size,
+		MinuteToExpires: 1,
+		TimeInForce:     "GTC",
+	}
+	log.Printf("action=sendOrder side=%s size=%f", side, size)
+	ai.logEstimatedSlippage(side, size)
+	resp, err := ai.API.SendOrderContext(ctx, order)
+	if err != nil {
+		switch {
+		case bitflyer.IsInsufficientFunds(err):
+			log.Printf("action=sendOrder status=insufficient_funds side=%s size=%f err=%s", side, size, err.Error())
+		case bitflyer.IsAuthFailure(err):
+			log.Printf("action=sendOrder status=auth_failure err=%s", err.Error())
+		default:
+			log.Printf("action=sendOrder err=%s", err.Error())
+		}
+		return 0, 0, false
+	}
+	if resp.ChildOrderAcceptanceID == "" {
+		log.Printf("action=sendOrder err=order is not accepted side=%s size=%f", side, size)
+		return 0, 0, false
+	}
+
+	completedOrder := ai.waitUntilOrderComplete(ctx, resp.ChildOrderAcceptanceID)
+	if completedOrder == nil {
+		return 0, 0, false
+	}
+	executedPrice, executedSize = ai.getExecutedPriceAndSize(ctx, completedOrder)
+	return executedPrice, executedSize, true
+}
+
+// 板から成行注文の平均約定価格とスリッページを見積もってログに出力する
+func (ai *AI) logEstimatedSlippage(side string, size float64) {
+	if ai.OrderBook == nil || !ai.OrderBook.Ready() {
+		return
+	}
+	slippage, err := ai.OrderBook.EstimateSlippage(side, size)
+	if err != nil {
+		log.Printf("action=logEstimatedSlippage err=%s", err.Error())
+		return
+	}
+	price, _ := ai.OrderBook.EstimateExecution(side, size)
+	log.Printf("action=logEstimatedSlippage side=%s size=%f price=%f slippage=%f", side, size, price, slippage)
+}
+
+// 注文の約定履歴から実際の約定価格(加重平均)と手数料を差し引いた約定数量を計算する処理を定義
+// 約定履歴を取得できない場合は注文の平均約定価格と約定数量を使用する
+func (ai *AI) getExecutedPriceAndSize(ctx context.Context, order *bitflyer.Order) (price, size float64) {
+	executions, err := ai.API.GetMyExecutionsContext(ctx, map[string]string{
+		"product_code":              ai.ProductCode,
+		"child_order_acceptance_id": order.ChildOrderAcceptanceID,
+	})
+	if err != nil || len(executions) == 0 {
+		if err != nil {
+			log.Printf("action=getExecutedPriceAndSize err=%s", err.Error())
+		}
+		return order.AveragePrice, order.ExecutedSize
+	}
+
+	price, size, commission := bitflyer.SummarizeExecutions(executions)
+	log.Printf("action=getExecutedPriceAndSize id=%s price=%f size=%f commission=%f",
+		order.ChildOrderAcceptanceID, price, size, commission)
+	// 現物の買い注文は手数料がコインで差し引かれるため、受け取った数量を記録する
+	if !ai.isMargin() && order.Side == models.SignalBuy {
+		size = roundDownSize(size - commission)
+	}
+	return price, size
+}
+
+// 注文が約定(COMPLETED)するまでListOrderで状態を確認する処理を定義(約定しなかった場合はnilを返す)
+func (ai *AI) waitUntilOrderComplete(ctx context.Context, childOrderAcceptanceID string) *bitflyer.Order {
+	if ai.OrderEvents != nil {
+		event, received := ai.OrderEvents.Wait(ctx, childOrderAcceptanceID)
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("action=waitUntilOrderComplete status=canceled id=%s", childOrderAcceptanceID)
+			return nil
+		case !received:
+			// イベントを受信できない場合はListOrderの定期確認で約定を待つ
+			log.Printf("action=waitUntilOrderComplete status=no_events id=%s", childOrderAcceptanceID)
+		case event == nil:
+			ai.cancelOrder(ctx, childOrderAcceptanceID)
+			return nil
+		case event.EventType != bitflyer.EventExecution:
+			log.Printf("action=waitUntilOrderComplete status=%s id=%s", event.EventType, childOrderAcceptanceID)
+			return nil
+		default:
+			// 約定したイベントを受信しているため、ListOrderで約定した注文の情報を取得する
+			return ai.pollOrderComplete(ctx, childOrderAcceptanceID, true)
+		}
+	}
+	return ai.pollOrderComplete(ctx, childOrderAcceptanceID, false)
+}
+
+// ListOrderで注文の状態を1秒間隔で最大60回確認する(immediateがtrueの場合は1回目を待たずに確認する)
+func (ai *AI) pollOrderComplete(ctx context.Context, childOrderAcceptanceID string, immediate bool) *bitflyer.Order {
+	params := map[string]string{
+		"product_code":              ai.ProductCode,
+		"child_order_acceptance_id": childOrderAcceptanceID,
+	}
+	for i := 0; i < 60; i++ {
+		if i > 0 || !immediate {
+			select {
+			case <-ctx.Done():
+				log.Printf("action=waitUntilOrderComplete status=canceled id=%s", childOrderAcceptanceID)
+				return nil
+			case <-time.After(time.Second):
+			}
+		}
+		orders, err := ai.API.ListOrderContext(ctx, params)
+		if err != nil {
+			log.Printf("action=waitUntilOrderComplete err=%s", err.Error())
+			continue
+		}
+		if len(orders) == 0 {
+			continue
+		}
+		switch orders[0].ChildOrderState {
+		case "COMPLETED":
+			log.Printf("action=waitUntilOrderComplete status=completed id=%s price=%f size=%f",
+				childOrderAcceptanceID, orders[0].AveragePrice, orders[0].ExecutedSize)
+			return &orders[0]
+		case "CANCELED", "EXPIRED", "REJECTED":
+			log.Printf("action=waitUntilOrderComplete status=%s id=%s", orders[0].ChildOrderState, childOrderAcceptanceID)
+			return nil
+		}
+	}
+	ai.cancelOrder(ctx, childOrderAcceptanceID)
+	return nil
+}
+
+// 約定しないまま残った注文を取り消す
+func (ai *AI) cancelOrder(ctx context.Context, childOrderAcceptanceID string) {
+	log.Printf("action=waitUntilOrderComplete status=timeout id=%s", childOrderAcceptanceID)
+	cancel := &bitflyer.CancelChildOrder{ProductCode: ai.ProductCode, ChildOrderAcceptanceID: childOrderAcceptanceID}
+	if err := ai.API.CancelChildOrderContext(ctx, cancel); err != nil {
+		log.Printf("action=waitUntilOrderComplete cancel err=%s", err.Error())
+	}
+}
+
+// 未約定の注文を全て取り消す処理を定義(停止時に注文を残さないために使用)
+func (ai *AI) CancelAllOrders(ctx context.Context) error {
+	if ai.BackTest {
+		return nil
+	}
+	if err := ai.API.CancelAllChildOrdersContext(ctx, ai.ProductCode); err != nil {
+		log.Printf("action=CancelAllOrders err=%s", err.Error())
+		return err
+	}
+	log.Printf("action=CancelAllOrders product_code=%s", ai.ProductCode)
+	return nil
+}
+
+// 証拠金取引(FX_BTC_JPYなど)の銘柄かどうかを判定する
+func (ai *AI) isMargin() bool {
+	return strings.HasPrefix(ai.ProductCode, "FX_")
+}
+
+// 売買に使用できる通貨(JPY)とコイン(BTC)の残高を取得する処理を定義
+// 証拠金取引の場合は新規の建玉に使用できる証拠金と、買いの建玉の数量を返す
+func (ai *AI) getAvailableBalance(ctx context.Context) (availableCurrency, availableCoin float64) {
+	if ai.isMargin() {
+		return ai.getAvailableCollateral(ctx)
+	}
+	balances, err := ai.API.GetBalanceContext(ctx)
+	if err != nil {
+		return
+	}
+	for _, balance := range balances {
+		if balance.CurrentCode == ai.CurrencyCode {
+			availableCurrency = balance.Available
+		} else if balance.CurrentCode == ai.CoinCode {
+			availableCoin = balance.Available
+		}
+	}
+	return
+}
+
+func (ai *AI) getAvailableCollateral(ctx context.Context) (availableCollateral, longSize float64) {
+	collateral, err := ai.API.GetCollateralContext(ctx)
+	if err != nil {
+		log.Printf("action=getAvailableCollateral err=%s", err.Error())
+		return
+	}
+	positions, err := ai.API.GetPositionsContext(ctx, ai.ProductCode)
+	if err != nil {
+		log.Printf("action=getAvailableCollateral err=%s", err.Error())
+		return
+	}
+	log.Printf("action=getAvailableCollateral collateral=%f require_collateral=%f keep_rate=%f",
+		collateral.Collateral, collateral.RequireCollateral, collateral.KeepRate)
+	return math.Max(collateral.Available(), 0), math.Max(bitflyer.NetPositionSize(positions), 0)
+}
+
+// 注文数量を小数点以下8桁で切り捨てる
+func roundDownSize(size float64) float64 {
+	return math.Floor(size*1e8) / 1e8
+}
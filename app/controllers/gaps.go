@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"gotrading/app/models"
+	"gotrading/bitflyer"
+	"gotrading/config"
+	"log"
+	"sort"
+	"time"
+)
+
+// configで指定された時間足を短い順に返す
+func ConfigDurations() []time.Duration {
+	durations := make([]time.Duration, 0, len(config.Config.Durations))
+	for _, duration := range config.Config.Durations {
+		durations = append(durations, duration)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations
+}
+
+// bitFlyerの約定履歴を新しい順に取得してsince以降のCandleを復元し、保存済みのCandleに反映する処理を定義
+// beforeを指定した場合はそのidより古い約定から取得する(0の場合は最新の約定から)
+// 取得が途中で失敗した場合も取得済みの約定履歴は反映し、最後に取得した約定のidをログに出力する
+func BackfillCandles(ctx context.Context, apiClient *bitflyer.APIClient, productCode string, durations []time.Duration, since time.Time, before int) ([]models.BackfillResult, error) {
+	to := time.Now().UTC()
+	if before > 0 {
+		// 途中の約定から取得する場合は、取得した最も新しい約定までを約定履歴が揃っている期間とする
+		to = time.Time{}
+	}
+	b := models.NewBackfill(productCode, durations, since, to)
+
+	var oldestID int
+	err := apiClient.PageExecutionsContext(ctx, productCode, before, 0, func(executions []bitflyer.Execution) bool {
+		for _, execution := range executions {
+			if !b.Add(execution) {
+				return false
+			}
+			oldestID = execution.ID
+		}
+		log.Printf("action=BackfillCandles product_code=%s executions=%d oldest_id=%d", productCode, b.Executions, oldestID)
+		return true
+	})
+	if err != nil {
+		log.Printf("action=BackfillCandles product_code=%s oldest_id=%d err=%s", productCode, oldestID, err.Error())
+	}
+
+	results, saveErr := b.Save()
+	if saveErr != nil {
+		return results, saveErr
+	}
+	return results, err
+}
+
+// Candleの欠損の集計結果を定義
+type GapReport struct {
+	Since   time.Time          `json:"since"`
+	Until   time.Time          `json:"until"`
+	Missing int                `json:"missing"` // 欠けているCandleの合計
+	Gaps    []models.CandleGap `json:"gaps"`
+}
+
+// 銘柄と時間足ごとにsince以降のCandleの欠損を調べる処理を定義
+// 作成途中のCandleは含めないよう、時間足ごとに最後に完成したCandleまでを対象とする
+func ScanGaps(productCodes []string, durations []time.Duration, since time.Time) (*GapReport, error) {
+	now := time.Now().UTC()
+	report := &GapReport{Since: since, Until: now, Gaps: []models.CandleGap{}}
+	for _, productCode := range productCodes {
+		for _, duration := range durations {
+			gaps, err := models.FindCandleGaps(productCode, duration, since, now.Add(-duration))
+			if err != nil {
+				return nil, err
+			}
+			for _, gap := range gaps {
+				report.Missing += gap.Missing
+			}
+			report.Gaps = append(report.Gaps, gaps...)
+		}
+	}
+	return report, nil
+}
+
+// 欠損を修復する方法
+const (
+	RepairFill     = "fill"     // 直前の終値で埋める
+	RepairBackfill = "backfill" // 約定履歴から復元する
+)
+
+// 欠損を修復する処理を定義
+// backfillの場合は銘柄ごとに最も古い欠損から現在までの約定履歴を取得し、約定がなかった期間は欠けたまま残る
+func RepairGaps(ctx context.Context, apiClient *bitflyer.APIClient, gaps []models.CandleGap, method string) error {
+	switch method {
+	case RepairFill:
+		for _, gap := range gaps {
+			filled, err := models.FillCandleGap(gap)
+			if err != nil {
+				log.Printf("action=RepairGaps err=%s", err.Error())
+				continue
+			}
+			log.Printf("action=RepairGaps method=fill product_code=%s duration=%s from=%s filled=%d",
+				gap.ProductCode, gap.Duration, gap.From.Format(time.RFC3339), filled)
+		}
+		return nil
+	case RepairBackfill:
+		oldest := map[string]time.Time{}
+		for _, gap := range gaps {
+			if from, ok := oldest[gap.ProductCode]; !ok || gap.From.Before(from) {
+				oldest[gap.ProductCode] = gap.From
+			}
+		}
+		for productCode, since := range oldest {
+			results, err := BackfillCandles(ctx, apiClient, productCode, ConfigDurations(), since, 0)
+			if err != nil {
+				return err
+			}
+			for _, result := range results {
+				log.Printf("action=RepairGaps method=backfill product_code=%s duration=%s replaced=%d merged=%d",
+					productCode, result.Duration, result.Replaced, result.Merged)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown repair method: %s", method)
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"gotrading/bitflyer"
+	"log"
+	"sync"
+	"time"
+)
+
+// 注文後に最初のイベントを待つ時間(受信できない場合はListOrderの定期確認に切り替える)
+const orderEventFirstTimeout = 10 * time.Second
+
+// 注文が終了するイベントを待つ時間
+const orderEventFinalTimeout = 60 * time.Second
+
+// 注文のイベントを最後に受信してから保持する時間(Waitで待つ時間より長くする)
+const orderEventRetention = 10 * time.Minute
+
+// 1つの注文について受信したイベントの状態
+type orderEventState struct {
+	received  chan struct{} // 最初のイベントを受信すると閉じる
+	done      chan struct{} // 注文が終了するイベントを受信すると閉じる
+	final     *bitflyer.ChildOrderEvent
+	updatedAt time.Time
+}
+
+func newOrderEventState() *orderEventState {
+	return &orderEventState{received: make(chan struct{}), done: make(chan struct{}), updatedAt: time.Now()}
+}
+
+// Realtime APIで受信した注文のイベントをchild_order_acceptance_idごとに保持する構造体を定義
+// 注文のレスポンスより先にイベントを受信する場合があるため、待機していない注文のイベントも保持する
+type OrderEventTracker struct {
+	mu        sync.Mutex
+	orders    map[string]*orderEventState
+	available bool // 購読が終了した場合はfalseとなり、イベントを待たずにListOrderで確認する
+}
+
+// 注文のイベントを保持するOrderEventTrackerを生成するコンストラクタ
+func NewOrderEventTracker() *OrderEventTracker {
+	return &OrderEventTracker{orders: map[string]*orderEventState{}, available: true}
+}
+
+func (t *OrderEventTracker) state(childOrderAcceptanceID string) *orderEventState {
+	state, ok := t.orders[childOrderAcceptanceID]
+	if !ok {
+		state = newOrderEventState()
+		t.orders[childOrderAcceptanceID] = state
+	}
+	return state
+}
+
+// 受信したイベントを注文ごとの状態に反映する
+func (t *OrderEventTracker) Dispatch(events []bitflyer.ChildOrderEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range events {
+		event := events[i]
+		state := t.state(event.ChildOrderAcceptanceID)
+		state.updatedAt = time.Now()
+		select {
+		case <-state.received:
+		default:
+			close(state.received)
+		}
+		if state.final == nil && event.IsFinal() {
+			state.final = &event
+			close(state.done)
+		}
+	}
+	t.prune()
+}
+
+// 最後にイベントを受信してから一定時間が経過した注文を削除する
+// 待機がタイムアウトした注文や手動で発注した注文など、終了のイベントを受信しない注文も削除する
+func (t *OrderEventTracker) prune() {
+	for id, state := range t.orders {
+		if time.Since(state.updatedAt) > orderEventRetention {
+			delete(t.orders, id)
+		}
+	}
+}
+
+// イベントの購読が終了したことを記録する
+func (t *OrderEventTracker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.available = false
+}
+
+// 注文が終了するイベントを待つ
+// イベントを1件も受信できなかった場合はreceived=false、受信したが終了しなかった場合はevent=nilを返す
+func (t *OrderEventTracker) Wait(ctx context.Context, childOrderAcceptanceID string) (event *bitflyer.ChildOrderEvent, received bool) {
+	t.mu.Lock()
+	if !t.available {
+		t.mu.Unlock()
+		return nil, false
+	}
+	state := t.state(childOrderAcceptanceID)
+	t.mu.Unlock()
+
+	select {
+	case <-state.received:
+	case <-ctx.Done():
+		return nil, false
+	case <-time.After(orderEventFirstTimeout):
+		return nil, false
+	}
+	select {
+	case <-state.done:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return state.final, true
+	case <-ctx.Done():
+		return nil, true
+	case <-time.After(orderEventFinalTimeout):
+		return nil, true
+	}
+}
+
+// Realtime APIで自分の注文のイベントを購読し、trackerに反映する
+// 認証に失敗した場合はtrackerを停止し、以降はListOrderの定期確認で約定を待つ
+func streamOrderEvents(ctx context.Context, apiClient *bitflyer.APIClient, tracker *OrderEventTracker) {
+	childChannel := make(chan []bitflyer.ChildOrderEvent)
+	parentChannel := make(chan []bitflyer.ParentOrderEvent)
+	go func() {
+		if err := apiClient.GetRealTimeOrderEventsContext(ctx, childChannel, parentChannel); err != nil {
+			log.Printf("action=streamOrderEvents err=%s", err.Error())
+		}
+		tracker.Stop()
+	}()
+	go func() {
+		for {
+			select {
+			case events := <-childChannel:
+				for _, event := range events {
+					log.Printf("action=streamOrderEvents child_order_event=%s id=%s side=%s price=%f size=%f",
+						event.EventType, event.ChildOrderAcceptanceID, event.Side, event.Price, event.Size)
+				}
+				tracker.Dispatch(events)
+			case events := <-parentChannel:
+				for _, event := range events {
+					log.Printf("action=streamOrderEvents parent_order_event=%s id=%s parameter_index=%d",
+						event.EventType, event.ParentOrderAcceptanceID, event.ParameterIndex)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
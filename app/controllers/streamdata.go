@@ -1,24 +1,134 @@
 package controllers
 
 import (
+	"context"
 	"gotrading/app/models"
 	"gotrading/bitflyer"
 	"gotrading/config"
 	"log"
+	"time"
 )
 
+// 銘柄ごとの売買を行うAI(StreamIngestionDataで生成し、以降は変更しない)
+var Ais = map[string]*AI{}
+
+// Realtime APIで最新の状態に保たれる銘柄ごとの板(売買時のスリッページの見積もりと /api/board/ で使用)
+var OrderBooks = map[string]*bitflyer.OrderBook{}
+
 // bitFlyerから取得したデータをストリーミングする関数を定義
-func StreamIngestionData() {
+// configで指定された全ての銘柄のデータを取り込み、銘柄ごとの設定で売買を行う
+// ctxがキャンセルされるとストリーミングと実行中の売買処理を停止する
+func StreamIngestionData(ctx context.Context) {
+	apiClient := bitflyer.New(config.Config.ApiKey, config.Config.ApiSecret,
+		bitflyer.WithBaseURL(config.Config.BaseURL), bitflyer.WithWebSocketURL(config.Config.WebSocketURL))
+
+	// 約定の確認はListOrderの定期確認ではなく、Realtime APIの注文のイベントで行う
+	// 認証に失敗しても市場データの受信が止まらないよう、注文のイベントは別の接続で購読する
+	var orderEvents *OrderEventTracker
+	for _, productCode := range config.Config.ProductCodes {
+		if !config.Config.Products[productCode].BackTest {
+			orderEvents = NewOrderEventTracker()
+			streamOrderEvents(ctx, apiClient, orderEvents)
+			break
+		}
+	}
+
+	// 取り込んだCandleはメモリに保持し、時間足の切り替わりと一定間隔ごとにまとめてデータベースに書き込む
+	go models.RunCandleFlusher(ctx, config.Config.CandleFlushInterval)
+
+	// 全ての銘柄の板と約定履歴(またはTicker)は1つの接続でまとめて購読する
+	stream := apiClient.NewStream()
+	for _, productCode := range config.Config.ProductCodes {
+		product := config.Config.Products[productCode]
+
+		// configで指定された戦略で自動売買を行うAIを生成
+		strategy, err := models.NewStrategy(product.Strategy)
+		if err != nil {
+			log.Fatalf("action=StreamIngestionData product_code=%s err=%s", productCode, err.Error())
+		}
+		ai := NewAI(apiClient, productCode, product.TradeDuration, strategy,
+			product.UsePercent, product.DataLimit, product.BackTest, product.OptimizeInterval)
+		if !product.BackTest {
+			ai.OrderEvents = orderEvents
+		}
+		Ais[productCode] = ai
+
+		OrderBooks[productCode] = bitflyer.NewOrderBook(productCode)
+		ai.OrderBook = OrderBooks[productCode]
+		stream.SubscribeBoard(productCode, OrderBooks[productCode])
+
+		if config.Config.CandleSource == "ticker" {
+			streamTicker(ctx, stream, ai)
+		} else {
+			streamExecutions(ctx, stream, ai)
+		}
+	}
+	go stream.Run(ctx)
+}
+
+// 売買を行う時間足のCandleが新しく始まったかを判定する構造体を定義
+// 集計して生成する時間足(5mなど)はテーブルに保存しないため、Candleの生成ではなく時刻で判定する
+type tradeTrigger struct {
+	current time.Time
+}
+
+// candleTimeが前回より新しい時間足の時刻であればtrueを返す
+// 起動直後の最初の時刻は時間足の途中から取り込むため、記録するだけで売買は行わない
+func (t *tradeTrigger) next(candleTime time.Time) bool {
+	if !candleTime.After(t.current) {
+		return false
+	}
+	first := t.current.IsZero()
+	t.current = candleTime
+	return !first
+}
+
+// Tickerの仲値からCandleを生成する(Tickerからは期間ごとの出来高が分からないため、出来高は0になる)
+func streamTicker(ctx context.Context, stream *bitflyer.Stream, ai *AI) {
 	var tickerChannel = make(chan bitflyer.Ticker)
-	apiClient := bitflyer.New(config.Config.ApiKey, config.Config.ApiSecret)
-	go apiClient.GetRealTimeTicker(config.Config.ProductCode, tickerChannel)
+	stream.SubscribeTicker(ai.ProductCode, tickerChannel)
+	var trigger tradeTrigger
 	go func() {
-		for ticker := range tickerChannel {
+		for {
+			var ticker bitflyer.Ticker
+			select {
+			case ticker = <-tickerChannel:
+			case <-ctx.Done():
+				return
+			}
 			log.Printf("action=StreamIngestionData, %v", ticker)
 			for _, duration := range config.Config.Durations {
-				isCreated := models.CreateCandleWithDuration(ticker, ticker.ProductCode, duration)
-				if isCreated == true && duration == config.Config.TradeDuration {
-					// TODO
+				models.CreateCandleWithDuration(ticker, ticker.ProductCode, duration)
+			}
+			if trigger.next(ticker.TruncateDateTime(ai.Duration)) {
+				// 売買処理に時間がかかってもデータの取り込みが止まらないようにgoroutineで実行
+				go ai.Trade(ctx)
+			}
+		}
+	}()
+}
+
+// 約定履歴の約定価格と約定数量からCandleを生成する
+func streamExecutions(ctx context.Context, stream *bitflyer.Stream, ai *AI) {
+	var executionChannel = make(chan []bitflyer.Execution)
+	stream.SubscribeExecutions(ai.ProductCode, executionChannel)
+	var trigger tradeTrigger
+	go func() {
+		for {
+			var executions []bitflyer.Execution
+			select {
+			case executions = <-executionChannel:
+			case <-ctx.Done():
+				return
+			}
+			log.Printf("action=StreamIngestionData, product_code=%s executions=%d", ai.ProductCode, len(executions))
+			for _, execution := range executions {
+				for _, duration := range config.Config.Durations {
+					models.CreateCandleWithExecution(execution, ai.ProductCode, duration)
+				}
+				if trigger.next(execution.DateTime().Truncate(ai.Duration)) {
+					// 売買処理に時間がかかってもデータの取り込みが止まらないようにgoroutineで実行
+					go ai.Trade(ctx)
 				}
 			}
 		}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"gotrading/app/models"
@@ -8,8 +9,10 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 var templates = template.Must(template.ParseFiles("./app/views/google.html"))
@@ -18,13 +21,31 @@ var templates = template.Must(template.ParseFiles("./app/views/google.html"))
 func viewChartHandler(w http.ResponseWriter, r *http.Request) {
 	// dfcandle.goで定義したGetAllCandle関数に渡す引数を定義
 	limit := 100
-	duration := "1m" // 1s or 1m or 1h
-	durationTime := config.Config.Durations[duration]
+	duration := r.URL.Query().Get("duration") // 1s or 1m or 1h (省略時は1m)
+	if duration == "" {
+		duration = "1m"
+	}
+	durationTime, ok := config.Config.LookupDuration(duration)
+	if !ok {
+		http.Error(w, "Unknown duration", http.StatusBadRequest)
+		return
+	}
+
+	// product_codeの指定がない場合や設定されていない銘柄の場合は最初の銘柄を表示
+	productCode := r.URL.Query().Get("product_code")
+	if !config.Config.HasProduct(productCode) {
+		productCode = config.Config.ProductCode
+	}
 
 	// GetAllCandle関数に上記で定義した引数を渡して得られたデータをdfに格納
-	df, _ := models.GetAllCandle(config.Config.ProductCode, durationTime, limit)
+	// 集計して生成する時間足では集計に失敗する場合があるため、エラーの場合はInternalServerErrorを表示
+	df, err := models.GetAllCandle(productCode, durationTime, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	err := templates.ExecuteTemplate(w, "google.html", df.Candles)
+	err = templates.ExecuteTemplate(w, "google.html", df.Candles)
 	// エラーの場合はInternalServerErrorを表示
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -49,7 +70,7 @@ func APIError(w http.ResponseWriter, errMessage string, code int) {
 }
 
 // apiのエンドポイントを判定するための正規表現を定義
-var apiValidPath = regexp.MustCompile("^/api/candle/$")
+var apiValidPath = regexp.MustCompile("^/api/(candle|board|gaps)/$")
 
 func apiMakeHandler(fn func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -60,6 +81,7 @@ func apiMakeHandler(fn func(http.ResponseWriter, *http.Request)) http.HandlerFun
 		// 判定した結果、マッチングしたものが見当たらない(0)の場合は上記で定義したAPIError関数を使用してエラーを返す
 		if len(m) == 0 {
 			APIError(w, "Not found", http.StatusNotFound)
+			return
 		}
 
 		// 判定した結果、マッチングしたものが見つかった場合はResponseWriter(w)とRequest(r)を関数に返却する
@@ -78,6 +100,12 @@ func apiCandleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// configで指定されていない銘柄はテーブルが存在しないためエラーを返す
+	if !config.Config.HasProduct(productCode) {
+		APIError(w, "Unknown product_code", http.StatusBadRequest)
+		return
+	}
+
 	// browserからlimitを取得できるようにするための設定
 	strLimit := r.URL.Query().Get("limit")
 
@@ -97,10 +125,23 @@ func apiCandleHandler(w http.ResponseWriter, r *http.Request) {
 	if duration == "" {
 		duration = "1m"
 	}
-	durationTime := config.Config.Durations[duration]
+	// 5mや1dのように保存していない時間足は、保存している時間足のCandleを集計して返す
+	// configで指定されていない時間足はエラーを返す
+	durationTime, ok := config.Config.LookupDuration(duration)
+	if !ok {
+		APIError(w, "Unknown duration", http.StatusBadRequest)
+		return
+	}
 
 	// 想定外の処理を全て拾った後に、各項目のデフォルト値を「df」に代入
-	df, _ := models.GetAllCandle(productCode, durationTime, limit)
+	df, err := models.GetAllCandle(productCode, durationTime, limit)
+	if err != nil {
+		APIError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// クエリパラメータで指定されたテクニカル指標をレスポンスに追加
+	addIndicators(df, r.URL.Query())
 
 	// 「df」を使用して構造体をJSONに変換
 	js, err := json.Marshal(df)
@@ -116,13 +157,173 @@ func apiCandleHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func StartWebServer() error {
+// 板情報を返すapi(depthで片側あたりの価格の数を指定、デフォルトは20)
+// ex) /api/board/?product_code=BTC_JPY&depth=20
+func apiBoardHandler(w http.ResponseWriter, r *http.Request) {
+	productCode := r.URL.Query().Get("product_code")
+	if productCode == "" {
+		productCode = config.Config.ProductCode
+	}
+	orderBook, ok := OrderBooks[productCode]
+	if !ok {
+		APIError(w, "Unknown product_code", http.StatusBadRequest)
+		return
+	}
+	if !orderBook.Ready() {
+		APIError(w, "Board is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	js, err := json.Marshal(orderBook.Snapshot(getIntParam(r.URL.Query(), "depth", 20)))
+	if err != nil {
+		APIError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// Candleの欠損を返すapi(sinceは調べる期間、デフォルトは24h)
+// product_codeとdurationを省略した場合はconfigで指定された全ての銘柄と時間足を調べる
+// ex) /api/gaps/?product_code=BTC_JPY&duration=1m&since=24h
+func apiGapsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	productCodes := config.Config.ProductCodes
+	if productCode := query.Get("product_code"); productCode != "" {
+		if !config.Config.HasProduct(productCode) {
+			APIError(w, "Unknown product_code", http.StatusBadRequest)
+			return
+		}
+		productCodes = []string{productCode}
+	}
+
+	durations := ConfigDurations()
+	if duration := query.Get("duration"); duration != "" {
+		// 欠損を調べられるのはテーブルに保存している時間足のみ
+		durationTime, ok := config.Config.LookupDuration(duration)
+		if !ok || !models.IsStoredDuration(durationTime) {
+			APIError(w, "Unknown duration", http.StatusBadRequest)
+			return
+		}
+		durations = []time.Duration{durationTime}
+	}
+
+	since := 24 * time.Hour
+	if strSince := query.Get("since"); strSince != "" {
+		var err error
+		since, err = config.ParseDuration(strSince)
+		if err != nil || since <= 0 {
+			APIError(w, "Invalid since param", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := ScanGaps(productCodes, durations, time.Now().UTC().Add(-since))
+	if err != nil {
+		APIError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(report)
+	if err != nil {
+		APIError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// クエリパラメータで指定されたテクニカル指標をdfに追加する処理を定義
+// ex) ?sma=1&smaPeriod1=7&smaPeriod2=14&bbands=1&bbandsN=20&bbandsK=2&rsi=1&macd=1
+func addIndicators(df *models.DataFrameCandle, query url.Values) {
+	// 移動平均線(SMA, EMA)とヒストリカル・ボラティリティは期間の異なる3本まで同時に指定可能
+	if query.Get("sma") != "" {
+		df.AddSma(getIntParam(query, "smaPeriod1", 7))
+		df.AddSma(getIntParam(query, "smaPeriod2", 14))
+		df.AddSma(getIntParam(query, "smaPeriod3", 50))
+	}
+
+	if query.Get("ema") != "" {
+		df.AddEma(getIntParam(query, "emaPeriod1", 7))
+		df.AddEma(getIntParam(query, "emaPeriod2", 14))
+		df.AddEma(getIntParam(query, "emaPeriod3", 50))
+	}
+
+	if query.Get("bbands") != "" {
+		df.AddBBands(getIntParam(query, "bbandsN", 20), getFloatParam(query, "bbandsK", 2))
+	}
+
+	if query.Get("ichimoku") != "" {
+		df.AddIchimokuCloud(
+			getIntParam(query, "ichimokuConversion", 9),
+			getIntParam(query, "ichimokuBase", 26),
+			getIntParam(query, "ichimokuSpanB", 52))
+	}
+
+	if query.Get("rsi") != "" {
+		df.AddRsi(getIntParam(query, "rsiPeriod", 14))
+	}
+
+	if query.Get("macd") != "" {
+		df.AddMacd(
+			getIntParam(query, "macdPeriod1", 12),
+			getIntParam(query, "macdPeriod2", 26),
+			getIntParam(query, "macdPeriod3", 9))
+	}
+
+	if query.Get("hv") != "" {
+		df.AddHv(getIntParam(query, "hvPeriod1", 21))
+		df.AddHv(getIntParam(query, "hvPeriod2", 63))
+		df.AddHv(getIntParam(query, "hvPeriod3", 252))
+	}
+}
+
+// クエリパラメータを正の整数として取得(指定がない場合や不正な値の場合はデフォルト値を返す)
+func getIntParam(query url.Values, key string, defaultValue int) int {
+	value, err := strconv.Atoi(query.Get(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// クエリパラメータを正の小数として取得(指定がない場合や不正な値の場合はデフォルト値を返す)
+func getFloatParam(query url.Values, key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(query.Get(key), 64)
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// ctxがキャンセルされた場合は処理中のリクエストの完了を待ってから停止する
+func StartWebServer(ctx context.Context) error {
 	// /api/candle/ にアクセスされた時にapiMakeHandler関数を実行(引数として上記で定義したapiCandleHandler関数を指定)
 	http.HandleFunc("/api/candle/", apiMakeHandler(apiCandleHandler))
 
+	// /api/board/ にアクセスされた時に板情報を返す
+	http.HandleFunc("/api/board/", apiMakeHandler(apiBoardHandler))
+
+	// /api/gaps/ にアクセスされた時にCandleの欠損を返す
+	http.HandleFunc("/api/gaps/", apiMakeHandler(apiGapsHandler))
+
 	// /chart/ にアクセスされた時にviewChartHandlerを呼び出す
 	http.HandleFunc("/chart/", viewChartHandler)
 
 	// ListenAndServeでConfigで定義したPortに接続させる
-	return http.ListenAndServe(fmt.Sprintf(":%d", config.Config.Port), nil)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", config.Config.Port)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("action=StartWebServer err=%s", err.Error())
+		}
+	}()
+
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
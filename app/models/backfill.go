@@ -0,0 +1,180 @@
+package models
+
+import (
+	"fmt"
+	"gotrading/bitflyer"
+	"math"
+	"time"
+)
+
+// 約定履歴から復元中のCandle(始値と終値を約定のidで判定するため、約定を受け取る順番は問わない)
+type backfillCandle struct {
+	Candle
+	openID  int
+	closeID int
+}
+
+// 約定履歴からCandleを復元し、保存済みのCandleに反映する構造体を定義
+// from以降の約定を集計し、fromからtoまでの間に収まる期間のCandleは約定履歴から復元した値で置き換える
+// 期間の一部しか約定履歴がないCandleは、保存済みのCandleがなければ追加し、あれば高値と安値のみ反映する
+// 同じ約定履歴で何度実行しても結果が変わらないため、途中で中断した場合もそのまま再実行できる
+type Backfill struct {
+	ProductCode string
+	Executions  int // 集計した約定の件数
+
+	durations []time.Duration
+	from      time.Time
+	to        time.Time
+	oldest    time.Time // 集計した最も古い約定の日時
+	newest    time.Time // 集計した最も新しい約定の日時
+	reached   bool      // fromより古い約定まで取得したかどうか
+	candles   map[time.Duration]map[time.Time]*backfillCandle
+}
+
+// 約定履歴からCandleを復元するBackfillを生成するコンストラクタ
+// toは約定履歴を取得し始めた時点(最新の約定から取得する場合は現在時刻)を指定する
+// 途中の約定から取得する場合はtoにゼロ値を指定し、集計した最も新しい約定の日時までを約定履歴が揃っている期間とする
+func NewBackfill(productCode string, durations []time.Duration, from, to time.Time) *Backfill {
+	candles := map[time.Duration]map[time.Time]*backfillCandle{}
+	for _, duration := range durations {
+		candles[duration] = map[time.Time]*backfillCandle{}
+	}
+	return &Backfill{
+		ProductCode: productCode,
+		durations:   durations,
+		from:        from,
+		to:          to,
+		candles:     candles,
+	}
+}
+
+// 約定を集計する(fromより古い約定の場合は集計せずにfalseを返すため、取得を終了する判定に使用する)
+func (b *Backfill) Add(execution bitflyer.Execution) bool {
+	dateTime := execution.DateTime()
+	if dateTime.Before(b.from) {
+		b.reached = true
+		return false
+	}
+	if !b.to.IsZero() && dateTime.After(b.to) {
+		return true
+	}
+	if b.oldest.IsZero() || dateTime.Before(b.oldest) {
+		b.oldest = dateTime
+	}
+	if dateTime.After(b.newest) {
+		b.newest = dateTime
+	}
+	b.Executions++
+
+	for _, duration := range b.durations {
+		candleTime := dateTime.Truncate(duration)
+		candle, ok := b.candles[duration][candleTime]
+		if !ok {
+			candle = &backfillCandle{
+				Candle:  *NewCandle(b.ProductCode, duration, candleTime, execution.Price, execution.Price, execution.Price, execution.Price, 0),
+				openID:  execution.ID,
+				closeID: execution.ID,
+			}
+			b.candles[duration][candleTime] = candle
+		}
+		if execution.ID < candle.openID {
+			candle.openID = execution.ID
+			candle.Open = execution.Price
+		}
+		if execution.ID > candle.closeID {
+			candle.closeID = execution.ID
+			candle.Close = execution.Price
+		}
+		candle.High = math.Max(candle.High, execution.Price)
+		candle.Low = math.Min(candle.Low, execution.Price)
+		candle.Volume += execution.Size
+		candle.addSideVolume(execution.Side, execution.Size)
+	}
+	return true
+}
+
+// 約定履歴が揃っている期間の始まり(fromまで取得できなかった場合は最も古い約定の日時)
+func (b *Backfill) coveredFrom() time.Time {
+	if b.reached {
+		return b.from
+	}
+	return b.oldest
+}
+
+// 約定履歴が揃っている期間の終わり
+func (b *Backfill) coveredTo() time.Time {
+	if b.to.IsZero() {
+		return b.newest
+	}
+	return b.to
+}
+
+// 集計したdurationのCandleの最も古い時刻と最も新しい時刻
+func (b *Backfill) candleRange(duration time.Duration) (from, to time.Time) {
+	for candleTime := range b.candles[duration] {
+		if from.IsZero() || candleTime.Before(from) {
+			from = candleTime
+		}
+		if candleTime.After(to) {
+			to = candleTime
+		}
+	}
+	return from, to
+}
+
+// 保存済みのCandleへの反映結果を定義
+type BackfillResult struct {
+	Duration time.Duration
+	Replaced int // 約定履歴から復元した値で置き換えた(または追加した)Candleの数
+	Merged   int // 期間の一部のみ約定履歴があり、高値と安値のみ反映した(または追加した)Candleの数
+}
+
+// 集計したCandleをテーブルに反映する(時間足ごとに1つのトランザクションで反映する)
+func (b *Backfill) Save() ([]BackfillResult, error) {
+	var results []BackfillResult
+	if b.Executions == 0 {
+		return results, nil
+	}
+	coveredFrom, coveredTo := b.coveredFrom(), b.coveredTo()
+
+	for _, duration := range b.durations {
+		result := BackfillResult{Duration: duration}
+		tableName := GetCandleTableName(b.ProductCode, duration)
+		replace := fmt.Sprintf(`INSERT INTO %s (time, open, close, high, low, volume, buy_volume, sell_volume) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(time) DO UPDATE SET open = excluded.open, close = excluded.close, high = excluded.high, low = excluded.low,
+			volume = excluded.volume, buy_volume = excluded.buy_volume, sell_volume = excluded.sell_volume`, tableName)
+		merge := fmt.Sprintf(`INSERT INTO %s (time, open, close, high, low, volume, buy_volume, sell_volume) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(time) DO UPDATE SET high = MAX(high, excluded.high), low = MIN(low, excluded.low)`, tableName)
+
+		// 作成途中のCandleを含む場合があるため、メモリに保持しているCandleで上書きされないように書き込む
+		from, to := b.candleRange(duration)
+		err := candles.writeThrough(tableName, from, to, func() error {
+			tx, err := DbConnection.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			for candleTime, candle := range b.candles[duration] {
+				cmd := merge
+				// 期間全体の約定履歴がある場合のみ、約定履歴から復元した値で置き換える
+				if !candleTime.Before(coveredFrom) && !candleTime.Add(duration).After(coveredTo) {
+					cmd = replace
+					result.Replaced++
+				} else {
+					result.Merged++
+				}
+				_, err := tx.Exec(cmd, candleTime.Format(time.RFC3339), candle.Open, candle.Close, candle.High, candle.Low,
+					candle.Volume, candle.BuyVolume, candle.SellVolume)
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Commit()
+		})
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// バックテストで1回の売買に使用する数量(損益はこの数量あたりの金額で計算)
+const BackTestSize = 1.0
+
+// バックテストの結果を定義
+type BackTestResult struct {
+	ProductCode string        `json:"product_code"`
+	Duration    time.Duration `json:"duration"`
+	Strategy    string        `json:"strategy"`
+	Params      Strategy      `json:"params"`
+	Signals     *SignalEvents `json:"signals"`
+	Profit      float64       `json:"profit"`       // 決済済みの売買の損益合計
+	Trades      int           `json:"trades"`       // 決済済みの売買(BUY→SELL)の回数
+	WinRate     float64       `json:"win_rate"`     // 利益が出た売買の割合(0〜1)
+	MaxDrawdown float64       `json:"max_drawdown"` // 含み損益を含めた損益の最大下落幅
+	SharpeRatio float64       `json:"sharpe_ratio"` // Candleごとのリターンから計算した年率換算のシャープレシオ
+}
+
+// DBに保存されているCandleを使用してバックテストを行う処理を定義
+func RunBackTest(productCode string, duration time.Duration, limit int, strategy Strategy) (*BackTestResult, error) {
+	df, err := GetAllCandle(productCode, duration, limit)
+	if err != nil {
+		return nil, err
+	}
+	return df.BackTest(strategy), nil
+}
+
+// dfのCandleを先頭から順に戦略に渡し、判定が出たCandleの終値で約定したとみなして売買を記録する処理を定義
+// 売買の記録はメモリ上のSignalEventsにのみ保持し、signal_eventsテーブルには保存しない
+func (df *DataFrameCandle) BackTest(strategy Strategy) *BackTestResult {
+	result := &BackTestResult{
+		ProductCode: df.ProductCode,
+		Duration:    df.Duration,
+		Strategy:    strategy.Name(),
+		Params:      strategy,
+		Signals:     NewSignalEvents(),
+	}
+
+	lenCandles := len(df.Candles)
+	if lenCandles == 0 {
+		return result
+	}
+
+	signals := strategy.Signals(df)
+	returns := make([]float64, 0, lenCandles)
+	equity, peak := 0.0, 0.0
+	realized, buyPrice := 0.0, 0.0
+	wins := 0
+	holding := false
+
+	for i, candle := range df.Candles {
+		// 1本前のCandleから保有していた場合のみ、そのCandleのリターンを計上する
+		if i > 0 {
+			r := 0.0
+			if holding && df.Candles[i-1].Close > 0 {
+				r = candle.Close/df.Candles[i-1].Close - 1
+			}
+			returns = append(returns, r)
+		}
+
+		switch signals[i] {
+		case SignalBuy:
+			if result.Signals.Buy(df.ProductCode, candle.Time, candle.Close, BackTestSize, false) {
+				buyPrice = candle.Close
+				holding = true
+			}
+		case SignalSell:
+			if result.Signals.Sell(df.ProductCode, candle.Time, candle.Close, BackTestSize, false) {
+				profit := (candle.Close - buyPrice) * BackTestSize
+				realized += profit
+				result.Trades++
+				if profit > 0 {
+					wins++
+				}
+				holding = false
+			}
+		}
+
+		// 含み損益を含めた損益の推移から最大下落幅を計算
+		equity = realized
+		if holding {
+			equity += (candle.Close - buyPrice) * BackTestSize
+		}
+		peak = math.Max(peak, equity)
+		result.MaxDrawdown = math.Max(result.MaxDrawdown, peak-equity)
+	}
+
+	result.Profit = result.Signals.Profit()
+	if result.Trades > 0 {
+		result.WinRate = float64(wins) / float64(result.Trades)
+	}
+	result.SharpeRatio = sharpeRatio(returns, df.Duration)
+	return result
+}
+
+// リターンの平均を標準偏差で割り、1年あたりのCandleの本数で年率換算する
+// (暗号資産は24時間365日取引されるため、1年 = 365日として計算)
+func sharpeRatio(returns []float64, duration time.Duration) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+
+	sum := 0.0
+	for _, r := range returns {
+		sum += r
+	}
+	avg := sum / float64(len(returns))
+
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - avg) * (r - avg)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+
+	sharpe := avg / std
+	if duration > 0 {
+		sharpe *= math.Sqrt(float64(365*24*time.Hour) / float64(duration))
+	}
+	return sharpe
+}
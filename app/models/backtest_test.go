@@ -0,0 +1,138 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 指定した判定をそのまま返すテスト用の戦略(足りない分はHOLDとする)
+type fixedStrategy struct {
+	signals []string
+}
+
+func (s *fixedStrategy) Name() string {
+	return "fixed"
+}
+
+func (s *fixedStrategy) Signals(df *DataFrameCandle) []string {
+	signals := newHoldSignals(len(df.Candles))
+	copy(signals, s.signals)
+	return signals
+}
+
+func TestBackTest(t *testing.T) {
+	const (
+		B = SignalBuy
+		S = SignalSell
+		H = SignalHold
+	)
+	tests := []struct {
+		name            string
+		closes          []float64
+		signals         []string
+		wantProfit      float64
+		wantTrades      int
+		wantWinRate     float64
+		wantMaxDrawdown float64
+		wantSignals     int
+	}{
+		{"no candles", nil, nil, 0, 0, 0, 0, 0},
+		{"no signals", []float64{100, 110, 90}, nil, 0, 0, 0, 0, 0},
+		{"two winning trades", []float64{100, 110, 105, 120, 90, 95}, []string{B, H, H, S, B, S},
+			25, 2, 1, 5, 4},
+		{"one win and one loss", []float64{100, 120, 110, 100}, []string{B, S, B, S},
+			10, 2, 0.5, 10, 4},
+		// 未決済のBUYは損益と売買回数に含めないが、含み損は最大下落幅に含める
+		{"open position after a loss", []float64{100, 80, 90, 70}, []string{B, S, B, H},
+			-20, 1, 0, 40, 3},
+		// 保有していない状態のSELLと保有中のBUYは無視する
+		{"ignored signals", []float64{100, 90, 120, 130}, []string{S, B, B, S},
+			40, 1, 1, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := &fixedStrategy{signals: tt.signals}
+			got := newTestDataFrame(tt.closes...).BackTest(strategy)
+			if got.ProductCode != "BTC_JPY" || got.Duration != time.Minute || got.Strategy != "fixed" || got.Params != strategy {
+				t.Errorf("result = %s %s %s %v, want BTC_JPY 1m fixed", got.ProductCode, got.Duration, got.Strategy, got.Params)
+			}
+			if math.Abs(got.Profit-tt.wantProfit) > 1e-9 {
+				t.Errorf("Profit = %v, want %v", got.Profit, tt.wantProfit)
+			}
+			if got.Trades != tt.wantTrades {
+				t.Errorf("Trades = %d, want %d", got.Trades, tt.wantTrades)
+			}
+			if math.Abs(got.WinRate-tt.wantWinRate) > 1e-9 {
+				t.Errorf("WinRate = %v, want %v", got.WinRate, tt.wantWinRate)
+			}
+			if math.Abs(got.MaxDrawdown-tt.wantMaxDrawdown) > 1e-9 {
+				t.Errorf("MaxDrawdown = %v, want %v", got.MaxDrawdown, tt.wantMaxDrawdown)
+			}
+			if len(got.Signals.Signals) != tt.wantSignals {
+				t.Errorf("signals = %d, want %d", len(got.Signals.Signals), tt.wantSignals)
+			}
+			if err := got.Signals.Validate(); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestSharpeRatio(t *testing.T) {
+	tests := []struct {
+		name     string
+		returns  []float64
+		duration time.Duration
+		want     float64
+	}{
+		{"no returns", nil, time.Minute, 0},
+		{"one return", []float64{0.01}, time.Minute, 0},
+		{"constant returns", []float64{0.01, 0.01, 0.01}, time.Minute, 0},
+		// 平均0.02、標準偏差(標本)0.01*√2を、1日足なので√365倍する
+		{"daily", []float64{0.01, 0.03}, 24 * time.Hour, math.Sqrt2 * math.Sqrt(365)},
+		{"hourly", []float64{0.01, 0.03}, time.Hour, math.Sqrt2 * math.Sqrt(365*24)},
+		{"negative", []float64{-0.01, -0.03}, 24 * time.Hour, -math.Sqrt2 * math.Sqrt(365)},
+		{"zero duration is not annualized", []float64{0.01, 0.03}, 0, math.Sqrt2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sharpeRatio(tt.returns, tt.duration); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("sharpeRatio = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 保有していないCandleのリターンは0としてシャープレシオを計算する
+func TestBackTestSharpeRatio(t *testing.T) {
+	df := newTestDataFrame(100, 110, 121, 121)
+	got := df.BackTest(&fixedStrategy{signals: []string{SignalBuy, SignalHold, SignalSell}})
+	want := sharpeRatio([]float64{0.1, 0.1, 0}, time.Minute)
+	if math.Abs(got.SharpeRatio-want) > 1e-9 || got.SharpeRatio <= 0 {
+		t.Errorf("SharpeRatio = %v, want %v", got.SharpeRatio, want)
+	}
+}
+
+func TestRunBackTest(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	insertDataFrame(t, newTestDataFrame(100, 110, 105, 120, 90, 95))
+
+	strategy := &fixedStrategy{signals: []string{SignalBuy, SignalHold, SignalHold, SignalSell, SignalBuy, SignalSell}}
+	got, err := RunBackTest("BTC_JPY", time.Minute, 100, strategy)
+	if err != nil {
+		t.Fatalf("RunBackTest: %v", err)
+	}
+	if got.Profit != 25 || got.Trades != 2 || got.MaxDrawdown != 5 {
+		t.Errorf("result = profit %v trades %d max drawdown %v, want 25 2 5", got.Profit, got.Trades, got.MaxDrawdown)
+	}
+
+	// limitで直近のCandleに絞り込む(先頭のBUYは120で約定する)
+	got, err = RunBackTest("BTC_JPY", time.Minute, 3, &fixedStrategy{signals: []string{SignalBuy, SignalHold, SignalSell}})
+	if err != nil {
+		t.Fatalf("RunBackTest with limit: %v", err)
+	}
+	if got.Profit != -25 || got.Trades != 1 {
+		t.Errorf("result with limit = profit %v trades %d, want -25 1", got.Profit, got.Trades)
+	}
+}
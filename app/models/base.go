@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"gotrading/config"
 	"log"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -28,28 +29,96 @@ func init() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	createTables()
+}
 
+// signal_eventsと銘柄・時間足ごとのCandleのテーブルを生成し、以前の形式のsignal_eventsを移行する
+func createTables() {
 	// DB接続時にtableが存在しない場合は生成するQueryを定義
+	// 複数の銘柄が同じ時刻に売買できるよう、timeとproduct_codeの組み合わせを主キーとする
 	cmd := fmt.Sprintf(`
         CREATE TABLE IF NOT EXISTS %s (
-            time DATETIME PRIMARY KEY NOT NULL,
-            product_code STRING,
+            time DATETIME NOT NULL,
+            product_code STRING NOT NULL,
             side STRING,
             price FLOAT,
-            size FLOAT)`, tableNameSignalEvents)
-	DbConnection.Exec(cmd)
+            size FLOAT,
+            PRIMARY KEY (time, product_code))`, tableNameSignalEvents)
+	if _, err := DbConnection.Exec(cmd); err != nil {
+		log.Fatalf("action=createTables err=%s", err.Error())
+	}
+	if err := migrateSignalEvents(); err != nil {
+		log.Fatalf("action=migrateSignalEvents err=%s", err.Error())
+	}
 
-	for _, duration := range config.Config.Durations {
-		// tableName => ex) BTC_JPY_1m
-		tableName := GetCandleTableName(config.Config.ProductCode, duration)
-		c := fmt.Sprintf(`
+	for _, productCode := range config.Config.ProductCodes {
+		for _, duration := range config.Config.Durations {
+			if err := createCandleTable(GetCandleTableName(productCode, duration)); err != nil {
+				log.Fatalf("action=createCandleTable table=%s err=%s", GetCandleTableName(productCode, duration), err.Error())
+			}
+		}
+	}
+}
+
+// Candleのテーブルが存在しない場合は生成する
+func createCandleTable(tableName string) error {
+	// tableName => ex) BTC_JPY_1m
+	c := fmt.Sprintf(`
             CREATE TABLE IF NOT EXISTS %s (
             time DATETIME PRIMARY KEY NOT NULL,
             open FLOAT,
             close FLOAT,
             high FLOAT,
             low FLOAT,
-			volume FLOAT)`, tableName)
-		DbConnection.Exec(c)
+			volume FLOAT,
+			buy_volume FLOAT DEFAULT 0,
+			sell_volume FLOAT DEFAULT 0)`, tableName)
+	if _, err := DbConnection.Exec(c); err != nil {
+		return err
+	}
+
+	// 売買方向ごとの出来高を追加する前に作成したテーブルにはカラムを追加する(追加済みの場合はエラーになるため無視する)
+	for _, column := range []string{"buy_volume", "sell_volume"} {
+		DbConnection.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s FLOAT DEFAULT 0", tableName, column))
+	}
+	return nil
+}
+
+// timeのみを主キーとしていた以前のsignal_eventsテーブルを、timeとproduct_codeの組み合わせを主キーとするテーブルに作り直す
+// SQLiteでは主キーを変更できないため、新しいテーブルにレコードを移してから置き換える
+func migrateSignalEvents() error {
+	var schema string
+	err := DbConnection.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", tableNameSignalEvents).Scan(&schema)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(schema, "PRIMARY KEY (time, product_code)") {
+		return nil
+	}
+
+	tx, err := DbConnection.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	cmds := []string{
+		fmt.Sprintf(`
+        CREATE TABLE %s_new (
+            time DATETIME NOT NULL,
+            product_code STRING NOT NULL,
+            side STRING,
+            price FLOAT,
+            size FLOAT,
+            PRIMARY KEY (time, product_code))`, tableNameSignalEvents),
+		fmt.Sprintf("INSERT INTO %s_new SELECT time, product_code, side, price, size FROM %s", tableNameSignalEvents, tableNameSignalEvents),
+		fmt.Sprintf("DROP TABLE %s", tableNameSignalEvents),
+		fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s", tableNameSignalEvents, tableNameSignalEvents),
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
 	}
+	log.Printf("action=migrateSignalEvents status=migrated")
+	return tx.Commit()
 }
@@ -3,6 +3,8 @@ package models
 import (
 	"fmt"
 	"gotrading/bitflyer"
+	"gotrading/config"
+	"math"
 	"time"
 )
 
@@ -16,6 +18,8 @@ type Candle struct {
 	High        float64       `json:"high"`
 	Low         float64       `json:"low"`
 	Volume      float64       `json:"volume"`
+	BuyVolume   float64       `json:"buy_volume"`  // 買いの約定の数量(約定履歴から生成した場合のみ)
+	SellVolume  float64       `json:"sell_volume"` // 売りの約定の数量(約定履歴から生成した場合のみ)
 }
 
 // Candle Stickを生成するコンストラクタを定義
@@ -29,6 +33,8 @@ func NewCandle(productCode string, duration time.Duration, timeDate time.Time, o
 		high,
 		low,
 		volume,
+		0,
+		0,
 	}
 }
 
@@ -44,10 +50,10 @@ func (c *Candle) TableName() string {
 // 1. SQLクエリを発行してテーブルにレコードを追加する処理を定義
 func (c *Candle) Create() error {
 	// 上記のTableName関数で取得した各columnの値を「%s」に渡してSQL Queryを生成
-	cmd := fmt.Sprintf("INSERT INTO %s (time, open, close, high, low, volume) VALUES (?, ?, ?, ?, ?, ?)", c.TableName())
+	cmd := fmt.Sprintf("INSERT INTO %s (time, open, close, high, low, volume, buy_volume, sell_volume) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", c.TableName())
 
 	// sql実行後の処理結果は不要なため「_」で破棄し、エラーが発生した時のみエラーハンドリングの処理に渡せるように定義
-	_, err := DbConnection.Exec(cmd, c.Time.Format(time.RFC3339), c.Open, c.Close, c.High, c.Low, c.Volume)
+	_, err := DbConnection.Exec(cmd, c.Time.Format(time.RFC3339), c.Open, c.Close, c.High, c.Low, c.Volume, c.BuyVolume, c.SellVolume)
 	if err != nil {
 		return err
 	}
@@ -57,8 +63,8 @@ func (c *Candle) Create() error {
 // 2. 上記SQLクエリでINSERTしたレコードを保存する処理を定義
 func (c *Candle) Save() error {
 	// TableName関数で取得した内容を「%s」に渡して、timeで取得した単位のレコード情報に書き換える
-	cmd := fmt.Sprintf("UPDATE %s SET open = ?, close = ?, high = ?, low = ?, volume = ? WHERE time = ?", c.TableName())
-	_, err := DbConnection.Exec(cmd, c.Open, c.Close, c.High, c.Low, c.Volume, c.Time.Format(time.RFC3339))
+	cmd := fmt.Sprintf("UPDATE %s SET open = ?, close = ?, high = ?, low = ?, volume = ?, buy_volume = ?, sell_volume = ? WHERE time = ?", c.TableName())
+	_, err := DbConnection.Exec(cmd, c.Open, c.Close, c.High, c.Low, c.Volume, c.BuyVolume, c.SellVolume, c.Time.Format(time.RFC3339))
 	if err != nil {
 		return err
 	}
@@ -68,45 +74,127 @@ func (c *Candle) Save() error {
 // 3. product-code, 時刻形式(秒,分,時間), 時刻を引数に渡して、SELECT文で保存したレコード情報を取得する処理を定義
 func GetCandle(productCode string, duration time.Duration, dateTime time.Time) *Candle {
 	tableName := GetCandleTableName(productCode, duration)
-	cmd := fmt.Sprintf("SELECT time, open, close, high, low, volume FROM  %s WHERE time = ?", tableName)
+	cmd := fmt.Sprintf("SELECT time, open, close, high, low, volume, buy_volume, sell_volume FROM  %s WHERE time = ?", tableName)
 	row := DbConnection.QueryRow(cmd, dateTime.Format(time.RFC3339)) // QueryRowはマッチしたレコードを1行出力するメソッド
 	var candle Candle
-	err := row.Scan(&candle.Time, &candle.Open, &candle.Close, &candle.High, &candle.Low, &candle.Volume)
+	err := row.Scan(&candle.Time, &candle.Open, &candle.Close, &candle.High, &candle.Low, &candle.Volume, &candle.BuyVolume, &candle.SellVolume)
 	if err != nil {
 		return nil
 	}
-	return NewCandle(productCode, duration, candle.Time, candle.Open, candle.Close, candle.High, candle.Low, candle.Volume)
+	c := NewCandle(productCode, duration, candle.Time, candle.Open, candle.Close, candle.High, candle.Low, candle.Volume)
+	c.BuyVolume = candle.BuyVolume
+	c.SellVolume = candle.SellVolume
+	return c
 }
 
+// Tickerの仲値からCandleを生成する処理を定義(新しいCandleを生成した場合はtrueを返す)
+// TickerのVolumeは直近24時間の出来高でCandleの期間の出来高を求められないため、出来高は0とする
+// 生成・更新したCandleはメモリに保持し、RunCandleFlusherでまとめてデータベースに書き込む
 func CreateCandleWithDuration(ticker bitflyer.Ticker, productCode string, duration time.Duration) bool {
-	currentCandle := GetCandle(productCode, duration, ticker.TruncateDateTime(duration))
+	candleTime := ticker.TruncateDateTime(duration)
 	price := ticker.GetMidPrice()
-	if currentCandle == nil {
-		candle := NewCandle(productCode, duration, ticker.TruncateDateTime(duration),
-			price, price, price, price, ticker.Volume)
-		candle.Create()
-		return true
+	return candles.update(productCode, duration, candleTime, func() *Candle {
+		return NewCandle(productCode, duration, candleTime, price, price, price, price, 0)
+	}, func(currentCandle *Candle) {
+		currentCandle.High = math.Max(currentCandle.High, price)
+		currentCandle.Low = math.Min(currentCandle.Low, price)
+		currentCandle.Close = price
+	})
+}
+
+// 約定履歴(Realtime APIの lightning_executions)からCandleを生成する処理を定義
+// 約定価格でOHLCを更新し、約定数量を出来高に加算する(takerの売買方向ごとの出来高も合わせて集計する)
+// 新しいCandleを生成した場合はtrueを返す
+func CreateCandleWithExecution(execution bitflyer.Execution, productCode string, duration time.Duration) bool {
+	candleTime := execution.DateTime().Truncate(duration)
+	price := execution.Price
+	return candles.update(productCode, duration, candleTime, func() *Candle {
+		candle := NewCandle(productCode, duration, candleTime, price, price, price, price, execution.Size)
+		candle.addSideVolume(execution.Side, execution.Size)
+		return candle
+	}, func(currentCandle *Candle) {
+		currentCandle.High = math.Max(currentCandle.High, price)
+		currentCandle.Low = math.Min(currentCandle.Low, price)
+		currentCandle.Close = price
+		currentCandle.Volume += execution.Size
+		currentCandle.addSideVolume(execution.Side, execution.Size)
+	})
+}
+
+// 約定の売買方向(takerのside)ごとの出来高に加算する(板寄せなどでsideが空の場合は加算しない)
+func (c *Candle) addSideVolume(side string, size float64) {
+	switch side {
+	case "BUY":
+		c.BuyVolume += size
+	case "SELL":
+		c.SellVolume += size
 	}
+}
 
-	if currentCandle.High <= price {
-		currentCandle.High = price
-	} else if currentCandle.Low >= price {
-		currentCandle.Low = price
+// 保存している時間足のCandleを新しい順にlimit本取得し、時系列順(昇順)で返す処理を定義
+// 保存していない時間足の場合は、保存している時間足のCandleを集計して生成する(ex: 1mのCandleから5mや15mを生成)
+func GetAllCandle(productCode string, duration time.Duration, limit int) (*DataFrameCandle, error) {
+	if IsStoredDuration(duration) {
+		return getStoredCandle(productCode, duration, limit)
+	}
+	return getResampledCandle(productCode, duration, limit)
+}
+
+// Candleのテーブルを作成している時間足かどうか
+func IsStoredDuration(duration time.Duration) bool {
+	for _, d := range config.Config.Durations {
+		if d == duration {
+			return true
+		}
 	}
-	currentCandle.Volume += ticker.Volume
-	currentCandle.Close = price
-	currentCandle.Save()
 	return false
 }
 
+// durationを割り切れる保存している時間足のうち、最も長いものを返す(集計するCandleの本数を最小にするため)
+func resampleBaseDuration(duration time.Duration) (time.Duration, bool) {
+	var base time.Duration
+	for _, d := range config.Config.Durations {
+		if d < duration && duration%d == 0 && d > base {
+			base = d
+		}
+	}
+	return base, base > 0
+}
+
+// 保存している時間足のCandleを集計してdurationのCandleを生成する
+// 最も古いCandleは集計元が一部しかない可能性があるため、1本多く集計してから取り除く
+func getResampledCandle(productCode string, duration time.Duration, limit int) (*DataFrameCandle, error) {
+	base, ok := resampleBaseDuration(duration)
+	if !ok {
+		return nil, fmt.Errorf("no stored duration to resample into %s", duration)
+	}
+	ratio := int(duration / base)
+	df, err := getStoredCandle(productCode, base, (limit+1)*ratio)
+	if err != nil {
+		return nil, err
+	}
+	resampled, err := df.Resample(duration)
+	if err != nil {
+		return nil, err
+	}
+	if len(resampled.Candles) > limit {
+		resampled.Candles = resampled.Candles[len(resampled.Candles)-limit:]
+	}
+	return resampled, nil
+}
+
 // dfcandle.goで定義した情報を全て取得してデータを成形する処理を定義
-func GetAllCandle(productCode string, duration time.Duration, limit int) (dfCandle *DataFrameCandle, err error) {
+func getStoredCandle(productCode string, duration time.Duration, limit int) (dfCandle *DataFrameCandle, err error) {
+	// 書き込み中のCandleを見落とさないよう、書き込みが終わるまで待ってから読み込む
+	candles.flushMu.RLock()
+	defer candles.flushMu.RUnlock()
+
 	// base.goで定義したproductCodeと時刻情報を連結したテーブル名を取得する関数を使用してテーブル名を定義
 	tableName := GetCandleTableName(productCode, duration)
 
 	// SQLクエリ(tableNameのテーブルから指定した情報を降順に並び替え、且つ取得上限ありで取得し、取得した情報を昇順で並び変えて表示)
 	cmd := fmt.Sprintf(`SELECT * FROM (
-		SELECT time, open, close, high, low, volume FROM %s ORDER BY time DESC LIMIT ?
+		SELECT time, open, close, high, low, volume, buy_volume, sell_volume FROM %s ORDER BY time DESC LIMIT ?
 		) ORDER BY time ASC;`, tableName)
 	rows, err := DbConnection.Query(cmd, limit)
 	if err != nil {
@@ -128,7 +216,7 @@ func GetAllCandle(productCode string, duration time.Duration, limit int) (dfCand
 		candle.Duration = duration
 
 		// Scanメソッドを使用して各項目の情報をデータベースから1行ずつ取得
-		rows.Scan(&candle.Time, &candle.Open, &candle.Close, &candle.High, &candle.Low, &candle.Volume)
+		rows.Scan(&candle.Time, &candle.Open, &candle.Close, &candle.High, &candle.Low, &candle.Volume, &candle.BuyVolume, &candle.SellVolume)
 		dfCandle.Candles = append(dfCandle.Candles, candle)
 	}
 	err = rows.Err()
@@ -136,5 +224,34 @@ func GetAllCandle(productCode string, duration time.Duration, limit int) (dfCand
 		return
 	}
 
+	// メモリに保持している未書き込みのCandleを重ねて、最新のCandleを含める
+	dfCandle.Candles = mergeCandles(dfCandle.Candles, candles.unsaved(tableName), limit)
 	return dfCandle, nil
 }
+
+// 時刻順のCandleに未書き込みのCandleを重ね(同じ時刻の場合は置き換える)、新しい順にlimit本を時刻順で返す
+func mergeCandles(stored, unsaved []Candle, limit int) []Candle {
+	if len(unsaved) == 0 {
+		return stored
+	}
+	merged := make([]Candle, 0, len(stored)+len(unsaved))
+	i, j := 0, 0
+	for i < len(stored) || j < len(unsaved) {
+		switch {
+		case j >= len(unsaved) || (i < len(stored) && stored[i].Time.Before(unsaved[j].Time)):
+			merged = append(merged, stored[i])
+			i++
+		case i >= len(stored) || unsaved[j].Time.Before(stored[i].Time):
+			merged = append(merged, unsaved[j])
+			j++
+		default:
+			merged = append(merged, unsaved[j])
+			i++
+			j++
+		}
+	}
+	if len(merged) > limit {
+		merged = merged[len(merged)-limit:]
+	}
+	return merged
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 取り込み中のCandleをメモリに保持し、まとめてデータベースに書き込むキャッシュを定義
+// Tickerや約定のたびにSELECTとINSERT/UPDATEを発行せず、時間足が切り替わった時と一定間隔ごとに1つのトランザクションで書き込む
+// データベースの内容と未書き込みのCandle(pending)を合わせたものが常に最新の状態となる
+// 読み込む処理は書き込まずに、データベースの内容にメモリのCandleを重ねて返す
+type candleCache struct {
+	mu       sync.Mutex
+	current  map[string]*Candle   // テーブルごとの作成途中のCandle(書き込み後も保持し、次の更新でデータベースを読まないようにする)
+	pending  map[candleKey]Candle // 前回の書き込み以降に更新されたCandle
+	closed   chan struct{}        // 時間足が切り替わったことを書き込み処理に通知する
+	writing  map[string]timeRange // writeThroughで直接書き込んでいるテーブルと期間
+	deferred []func()             // writeThroughで書き込み中の期間に届いた更新(書き込み後に反映する)
+
+	// 書き込みを直列化する(読み込む処理は書き込み中のCandleを見落とさないよう、書き込みの完了を待ってから読み込む)
+	flushMu sync.RWMutex
+}
+
+type timeRange struct {
+	from, to time.Time
+}
+
+func (r timeRange) contains(t time.Time) bool {
+	return !t.Before(r.from) && !t.After(r.to)
+}
+
+type candleKey struct {
+	tableName string
+	time      int64 // Candleの時刻(Unix時間)
+}
+
+var candles = newCandleCache()
+
+func newCandleCache() *candleCache {
+	return &candleCache{
+		current: map[string]*Candle{},
+		pending: map[candleKey]Candle{},
+		closed:  make(chan struct{}, 1),
+		writing: map[string]timeRange{},
+	}
+}
+
+// candleTimeのCandleをapplyで更新する(新しいCandleの場合はcreateで生成する)
+// 新しいCandleを生成した場合はtrueを返す(writeThroughで書き込み中の期間の場合は書き込み後に反映し、falseを返す)
+func (cc *candleCache) update(productCode string, duration time.Duration, candleTime time.Time, create func() *Candle, apply func(*Candle)) bool {
+	tableName := GetCandleTableName(productCode, duration)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if r, ok := cc.writing[tableName]; ok && r.contains(candleTime) {
+		cc.deferred = append(cc.deferred, func() {
+			cc.updateLocked(tableName, productCode, duration, candleTime, create, apply)
+		})
+		return false
+	}
+	return cc.updateLocked(tableName, productCode, duration, candleTime, create, apply)
+}
+
+// muをロックした状態でCandleを更新する
+func (cc *candleCache) updateLocked(tableName string, productCode string, duration time.Duration, candleTime time.Time, create func() *Candle, apply func(*Candle)) bool {
+	key := candleKey{tableName, candleTime.Unix()}
+	candle := cc.lookup(key, productCode, duration)
+	created := candle == nil
+	if created {
+		candle = create()
+	} else {
+		apply(candle)
+	}
+	cc.pending[key] = *candle
+
+	// 作成途中のCandleより新しいCandleが始まった場合は、完成したCandleを書き込むよう通知する
+	if current := cc.current[tableName]; current == nil || candleTime.After(current.Time) {
+		if current != nil {
+			cc.notifyClosed()
+		}
+		cc.current[tableName] = candle
+	}
+	return created
+}
+
+// 更新するCandleをメモリ、データベースの順に探す(見つからない場合はnilを返す)
+// 返したCandleはcurrentの場合もあるため、muをロックしたまま更新する
+func (cc *candleCache) lookup(key candleKey, productCode string, duration time.Duration) *Candle {
+	if current := cc.current[key.tableName]; current != nil && current.Time.Unix() == key.time {
+		return current
+	}
+	if pending, ok := cc.pending[key]; ok {
+		return &pending
+	}
+	// 遅れて届いた約定などで作成途中より前のCandleを更新する場合と、起動直後はデータベースから読み込む
+	return GetCandle(productCode, duration, time.Unix(key.time, 0).UTC())
+}
+
+func (cc *candleCache) notifyClosed() {
+	select {
+	case cc.closed <- struct{}{}:
+	default:
+	}
+}
+
+// 未書き込みのCandleを1つのトランザクションでデータベースに書き込む
+func (cc *candleCache) flush() error {
+	cc.flushMu.Lock()
+	defer cc.flushMu.Unlock()
+	return cc.flushLocked()
+}
+
+// flushMuをロックした状態で未書き込みのCandleを書き込む
+// 書き込みに失敗した場合は、その後に更新されていないCandleを戻して次の書き込みで再度書き込む
+func (cc *candleCache) flushLocked() error {
+	cc.mu.Lock()
+	pending := cc.pending
+	cc.pending = map[candleKey]Candle{}
+	cc.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := saveCandles(pending); err != nil {
+		cc.mu.Lock()
+		for key, candle := range pending {
+			if _, ok := cc.pending[key]; !ok {
+				cc.pending[key] = candle
+			}
+		}
+		cc.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// tableNameのデータベースに書き込まれていないCandleを時刻順に返す
+// flushMuを読み込みでロックした状態で呼び出し、データベースから読み込んだCandleに重ねる
+func (cc *candleCache) unsaved(tableName string) []Candle {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	var unsaved []Candle
+	for key, candle := range cc.pending {
+		if key.tableName == tableName {
+			unsaved = append(unsaved, candle)
+		}
+	}
+	sort.Slice(unsaved, func(i, j int) bool { return unsaved[i].Time.Before(unsaved[j].Time) })
+	return unsaved
+}
+
+// Candleのテーブルに直接書き込む処理(約定履歴からの復元や欠損の補完)を、メモリのCandleで上書きされないように実行する
+// 書き込む前に未書き込みのCandleを書き込み、書き込み中に届いたfromからtoまでのCandleの更新は書き込み後に反映する
+// 書き込んだ後はtableNameのfromからtoまでの作成途中のCandleを破棄し、次の更新でデータベースから読み込み直す
+func (cc *candleCache) writeThrough(tableName string, from, to time.Time, write func() error) error {
+	cc.flushMu.Lock()
+	defer cc.flushMu.Unlock()
+	if err := cc.flushLocked(); err != nil {
+		return err
+	}
+
+	r := timeRange{from, to}
+	cc.mu.Lock()
+	cc.writing[tableName] = r
+	cc.mu.Unlock()
+
+	err := write()
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.writing, tableName)
+	if current := cc.current[tableName]; current != nil && r.contains(current.Time) {
+		delete(cc.current, tableName)
+	}
+	deferred := cc.deferred
+	cc.deferred = nil
+	for _, update := range deferred {
+		update()
+	}
+	return err
+}
+
+// Candleを時刻ごとに追加または置き換える(テーブルごとにステートメントを準備して使い回す)
+func saveCandles(pending map[candleKey]Candle) error {
+	tx, err := DbConnection.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := map[string]*sql.Stmt{}
+	for key, candle := range pending {
+		stmt, ok := stmts[key.tableName]
+		if !ok {
+			cmd := fmt.Sprintf(`INSERT INTO %s (time, open, close, high, low, volume, buy_volume, sell_volume) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(time) DO UPDATE SET open = excluded.open, close = excluded.close, high = excluded.high, low = excluded.low,
+				volume = excluded.volume, buy_volume = excluded.buy_volume, sell_volume = excluded.sell_volume`, key.tableName)
+			if stmt, err = tx.Prepare(cmd); err != nil {
+				return err
+			}
+			defer stmt.Close()
+			stmts[key.tableName] = stmt
+		}
+		_, err := stmt.Exec(candle.Time.Format(time.RFC3339), candle.Open, candle.Close, candle.High, candle.Low,
+			candle.Volume, candle.BuyVolume, candle.SellVolume)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 未書き込みのCandleをデータベースに書き込む
+func FlushCandles() error {
+	return candles.flush()
+}
+
+// 時間足が切り替わった時と、intervalごとに未書き込みのCandleをデータベースに書き込む処理を定義
+// ctxがキャンセルされると残りのCandleを書き込んで終了する
+func RunCandleFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-candles.closed:
+		case <-ctx.Done():
+			if err := FlushCandles(); err != nil {
+				log.Printf("action=RunCandleFlusher err=%s", err.Error())
+			}
+			return
+		}
+		if err := FlushCandles(); err != nil {
+			log.Printf("action=RunCandleFlusher err=%s", err.Error())
+		}
+	}
+}
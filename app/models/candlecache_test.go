@@ -0,0 +1,281 @@
+package models
+
+import (
+	"fmt"
+	"gotrading/bitflyer"
+	"testing"
+	"time"
+)
+
+// 指定した時刻・価格・数量の約定を生成する
+func newExecution(id int, dateTime time.Time, side string, price, size float64) bitflyer.Execution {
+	return bitflyer.Execution{ID: id, Side: side, Price: price, Size: size, ExecDate: dateTime.Format(time.RFC3339Nano)}
+}
+
+func assertCandle(t *testing.T, name string, got *Candle, open, close, high, low, volume float64) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("%s = nil, want open=%v close=%v high=%v low=%v volume=%v", name, open, close, high, low, volume)
+	}
+	if got.Open != open || got.Close != close || got.High != high || got.Low != low || got.Volume != volume {
+		t.Errorf("%s = open=%v close=%v high=%v low=%v volume=%v, want open=%v close=%v high=%v low=%v volume=%v", name,
+			got.Open, got.Close, got.High, got.Low, got.Volume, open, close, high, low, volume)
+	}
+}
+
+// 更新したCandleは書き込むまでデータベースに反映されない
+func TestCandleCacheFlush(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if !CreateCandleWithExecution(newExecution(1, base.Add(10*time.Second), "BUY", 100, 1), "BTC_JPY", time.Minute) {
+		t.Errorf("first execution did not create a candle")
+	}
+	CreateCandleWithExecution(newExecution(2, base.Add(20*time.Second), "SELL", 120, 2), "BTC_JPY", time.Minute)
+	if CreateCandleWithExecution(newExecution(3, base.Add(30*time.Second), "BUY", 90, 3), "BTC_JPY", time.Minute) {
+		t.Errorf("execution in the same minute created a new candle")
+	}
+	if candle := GetCandle("BTC_JPY", time.Minute, base); candle != nil {
+		t.Fatalf("candle = %+v before flush, want nil", candle)
+	}
+
+	if err := FlushCandles(); err != nil {
+		t.Fatalf("FlushCandles: %v", err)
+	}
+	candle := GetCandle("BTC_JPY", time.Minute, base)
+	assertCandle(t, "flushed candle", candle, 100, 90, 120, 90, 6)
+	if candle.BuyVolume != 4 || candle.SellVolume != 2 {
+		t.Errorf("buy volume = %v sell volume = %v, want 4 2", candle.BuyVolume, candle.SellVolume)
+	}
+}
+
+// 読み込む処理は書き込まずに、データベースのCandleに未書き込みのCandleを重ねて返す
+func TestCandleCacheRead(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	insertCandles(t, "BTC_JPY", time.Minute, base, base.Add(time.Minute))
+
+	// 保存済みのCandleの更新と、新しいCandleの追加
+	CreateCandleWithExecution(newExecution(1, base.Add(time.Minute+10*time.Second), "BUY", 130, 1), "BTC_JPY", time.Minute)
+	CreateCandleWithExecution(newExecution(2, base.Add(2*time.Minute), "SELL", 90, 2), "BTC_JPY", time.Minute)
+
+	df, err := GetAllCandle("BTC_JPY", time.Minute, 2)
+	if err != nil {
+		t.Fatalf("GetAllCandle: %v", err)
+	}
+	if len(df.Candles) != 2 {
+		t.Fatalf("candles = %d, want 2", len(df.Candles))
+	}
+	assertCandle(t, "updated candle", &df.Candles[0], 100, 130, 130, 100, 2)
+	assertCandle(t, "new candle", &df.Candles[1], 90, 90, 90, 90, 2)
+	if !df.Candles[1].Time.Equal(base.Add(2 * time.Minute)) {
+		t.Errorf("last candle time = %s, want %s", df.Candles[1].Time, base.Add(2*time.Minute))
+	}
+
+	if gaps, err := FindCandleGaps("BTC_JPY", time.Minute, base, base.Add(2*time.Minute)); err != nil || len(gaps) != 0 {
+		t.Errorf("gaps = %+v err = %v, want none", gaps, err)
+	}
+	if candle := GetCandle("BTC_JPY", time.Minute, base.Add(2*time.Minute)); candle != nil {
+		t.Errorf("candle = %+v after reads, want nil (reads must not flush)", candle)
+	}
+	if len(candles.pending) != 2 {
+		t.Errorf("pending = %d after reads, want 2", len(candles.pending))
+	}
+}
+
+// 直接書き込んでいる間もCandleの更新は待たされず、書き込んだ期間の更新は書き込み後に反映される
+func TestCandleCacheWriteThroughDefersUpdates(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tableName := GetCandleTableName("BTC_JPY", time.Minute)
+	CreateCandleWithExecution(newExecution(1, base.Add(10*time.Second), "BUY", 100, 1), "BTC_JPY", time.Minute)
+
+	writing := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- candles.writeThrough(tableName, base, base, func() error {
+			close(writing)
+			<-release
+			_, err := DbConnection.Exec(fmt.Sprintf(`UPDATE %s SET open = 200, close = 200, high = 200, low = 200, volume = 5 WHERE time = ?`, tableName),
+				base.Format(time.RFC3339))
+			return err
+		})
+	}()
+	<-writing
+
+	updated := make(chan struct{})
+	go func() {
+		CreateCandleWithExecution(newExecution(2, base.Add(20*time.Second), "BUY", 210, 1), "BTC_JPY", time.Minute)
+		CreateCandleWithExecution(newExecution(3, base.Add(time.Minute), "BUY", 300, 1), "BTC_JPY", time.Minute)
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("updates blocked while writing")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("writeThrough: %v", err)
+	}
+
+	if err := FlushCandles(); err != nil {
+		t.Fatalf("FlushCandles: %v", err)
+	}
+	assertCandle(t, "written candle", GetCandle("BTC_JPY", time.Minute, base), 200, 210, 210, 200, 6)
+	assertCandle(t, "next candle", GetCandle("BTC_JPY", time.Minute, base.Add(time.Minute)), 300, 300, 300, 300, 1)
+}
+
+// 新しい時間足のCandleが始まると、完成したCandleを書き込むよう通知する
+func TestCandleCacheNotifyClosed(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	CreateCandleWithExecution(newExecution(1, base, "BUY", 100, 1), "BTC_JPY", time.Minute)
+	CreateCandleWithExecution(newExecution(2, base.Add(30*time.Second), "BUY", 110, 1), "BTC_JPY", time.Minute)
+	select {
+	case <-candles.closed:
+		t.Fatalf("notified before the candle closed")
+	default:
+	}
+
+	CreateCandleWithExecution(newExecution(3, base.Add(time.Minute), "BUY", 120, 1), "BTC_JPY", time.Minute)
+	select {
+	case <-candles.closed:
+	default:
+		t.Fatalf("not notified after the candle closed")
+	}
+}
+
+// 書き込みに失敗したCandleは戻され、その後の更新と合わせて次の書き込みで反映される
+func TestCandleCacheFlushFailure(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tableName := GetCandleTableName("BTC_JPY", time.Minute)
+	renameTable := func(from, to string) {
+		t.Helper()
+		if _, err := DbConnection.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", from, to)); err != nil {
+			t.Fatalf("rename %s: %v", from, err)
+		}
+	}
+
+	CreateCandleWithExecution(newExecution(1, base, "BUY", 100, 1), "BTC_JPY", time.Minute)
+	CreateCandleWithExecution(newExecution(2, base.Add(time.Minute), "BUY", 200, 1), "BTC_JPY", time.Minute)
+
+	renameTable(tableName, tableName+"_moved")
+	restored := false
+	defer func() {
+		if !restored {
+			renameTable(tableName+"_moved", tableName)
+		}
+	}()
+	if err := FlushCandles(); err == nil {
+		t.Fatalf("FlushCandles succeeded without the table")
+	}
+	if len(candles.pending) != 2 {
+		t.Fatalf("pending = %d after the failed flush, want 2", len(candles.pending))
+	}
+
+	// 失敗した後に更新したCandleは、戻したCandleで置き換えない
+	CreateCandleWithExecution(newExecution(3, base.Add(time.Minute+30*time.Second), "SELL", 210, 2), "BTC_JPY", time.Minute)
+	renameTable(tableName+"_moved", tableName)
+	restored = true
+
+	if err := FlushCandles(); err != nil {
+		t.Fatalf("FlushCandles: %v", err)
+	}
+	if len(candles.pending) != 0 {
+		t.Errorf("pending = %d after the flush, want 0", len(candles.pending))
+	}
+	assertCandle(t, "first candle", GetCandle("BTC_JPY", time.Minute, base), 100, 100, 100, 100, 1)
+	assertCandle(t, "second candle", GetCandle("BTC_JPY", time.Minute, base.Add(time.Minute)), 200, 210, 210, 200, 3)
+}
+
+// 約定履歴から復元したCandleを、メモリに保持している作成途中のCandleで上書きしない
+func TestCandleCacheBackfillOverwrite(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 取りこぼした約定がある状態で作成途中のCandle
+	CreateCandleWithExecution(newExecution(2, base.Add(20*time.Second), "BUY", 150, 1), "BTC_JPY", time.Minute)
+
+	backfill := NewBackfill("BTC_JPY", []time.Duration{time.Minute}, base, base.Add(time.Minute))
+	for _, execution := range []bitflyer.Execution{
+		newExecution(3, base.Add(40*time.Second), "SELL", 140, 1),
+		newExecution(2, base.Add(20*time.Second), "BUY", 150, 1),
+		newExecution(1, base.Add(10*time.Second), "BUY", 100, 1),
+		newExecution(0, base.Add(-time.Second), "BUY", 90, 1),
+	} {
+		backfill.Add(execution)
+	}
+	results, err := backfill.Save()
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if len(results) != 1 || results[0].Replaced != 1 {
+		t.Fatalf("results = %+v, want 1 replaced candle", results)
+	}
+	assertCandle(t, "backfilled candle", GetCandle("BTC_JPY", time.Minute, base), 100, 140, 150, 100, 3)
+
+	// 続けて届いた約定は復元したCandleに反映する
+	CreateCandleWithExecution(newExecution(4, base.Add(50*time.Second), "BUY", 160, 1), "BTC_JPY", time.Minute)
+	if err := FlushCandles(); err != nil {
+		t.Fatalf("FlushCandles: %v", err)
+	}
+	assertCandle(t, "updated candle", GetCandle("BTC_JPY", time.Minute, base), 100, 160, 160, 100, 4)
+}
+
+// 未書き込みのCandleがある時刻を、欠損として直前の終値で埋めない
+func TestCandleCacheFillGap(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	insertCandles(t, "BTC_JPY", time.Minute, base, base.Add(4*time.Minute))
+
+	CreateCandleWithExecution(newExecution(1, base.Add(2*time.Minute), "BUY", 130, 1), "BTC_JPY", time.Minute)
+	filled, err := FillCandleGap(CandleGap{
+		ProductCode: "BTC_JPY",
+		Duration:    time.Minute,
+		From:        base.Add(time.Minute),
+		To:          base.Add(3 * time.Minute),
+		Missing:     3,
+	})
+	if err != nil || filled != 2 {
+		t.Fatalf("filled = %d err = %v, want 2", filled, err)
+	}
+	assertCandle(t, "filled candle", GetCandle("BTC_JPY", time.Minute, base.Add(time.Minute)), 100, 100, 100, 100, 0)
+	assertCandle(t, "received candle", GetCandle("BTC_JPY", time.Minute, base.Add(2*time.Minute)), 130, 130, 130, 130, 1)
+
+	CreateCandleWithExecution(newExecution(2, base.Add(2*time.Minute+30*time.Second), "SELL", 120, 1), "BTC_JPY", time.Minute)
+	if err := FlushCandles(); err != nil {
+		t.Fatalf("FlushCandles: %v", err)
+	}
+	assertCandle(t, "updated candle", GetCandle("BTC_JPY", time.Minute, base.Add(2*time.Minute)), 130, 120, 130, 120, 2)
+}
+
+// Tickerの仲値でOHLCを更新し、24時間の出来高は加算しない
+func TestCreateCandleWithDuration(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTicker := func(second int, mid float64) bitflyer.Ticker {
+		return bitflyer.Ticker{ProductCode: "BTC_JPY", Timestamp: base.Add(time.Duration(second) * time.Second).Format(time.RFC3339Nano),
+			BestBid: mid - 1, BestAsk: mid + 1, Volume: 5000}
+	}
+
+	if !CreateCandleWithDuration(newTicker(0, 100), "BTC_JPY", time.Minute) {
+		t.Errorf("first ticker did not create a candle")
+	}
+	for i, mid := range []float64{120, 90, 110} {
+		if CreateCandleWithDuration(newTicker(10*(i+1), mid), "BTC_JPY", time.Minute) {
+			t.Errorf("ticker in the same minute created a new candle")
+		}
+	}
+	if !CreateCandleWithDuration(newTicker(60, 105), "BTC_JPY", time.Minute) {
+		t.Errorf("ticker in the next minute did not create a candle")
+	}
+	if err := FlushCandles(); err != nil {
+		t.Fatalf("FlushCandles: %v", err)
+	}
+	assertCandle(t, "first candle", GetCandle("BTC_JPY", time.Minute, base), 100, 110, 120, 90, 0)
+	assertCandle(t, "second candle", GetCandle("BTC_JPY", time.Minute, base.Add(time.Minute)), 105, 105, 105, 105, 0)
+}
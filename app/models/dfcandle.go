@@ -1,12 +1,75 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"gotrading/tradingalgo"
+	"math"
+	"time"
+)
 
 // データフレームの構造体を定義
 type DataFrameCandle struct {
 	ProductCode string        `json:"product_code"`
 	Duration    time.Duration `json:"duration"`
 	Candles     []Candle      `json:"candles"`
+	Indicators
+}
+
+// APIのレスポンスとしてCandleと一緒に返すテクニカル指標の構造体を定義
+// DataFrameCandleに埋め込むことで、JSONではcandlesと同じ階層に出力される
+type Indicators struct {
+	Smas          []Sma          `json:"smas,omitempty"`
+	Emas          []Ema          `json:"emas,omitempty"`
+	BBands        *BBands        `json:"bbands,omitempty"`
+	IchimokuCloud *IchimokuCloud `json:"ichimoku,omitempty"`
+	Rsi           *Rsi           `json:"rsi,omitempty"`
+	Macd          *Macd          `json:"macd,omitempty"`
+	Hvs           []Hv           `json:"hvs,omitempty"`
+}
+
+type Sma struct {
+	Period int       `json:"period,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+type Ema struct {
+	Period int       `json:"period,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+type BBands struct {
+	N    int       `json:"n,omitempty"`
+	K    float64   `json:"k,omitempty"`
+	Up   []float64 `json:"up,omitempty"`
+	Mid  []float64 `json:"mid,omitempty"`
+	Down []float64 `json:"down,omitempty"`
+}
+
+type IchimokuCloud struct {
+	Tenkan  []float64 `json:"tenkan,omitempty"`
+	Kijun   []float64 `json:"kijun,omitempty"`
+	SenkouA []float64 `json:"senkoua,omitempty"`
+	SenkouB []float64 `json:"senkoub,omitempty"`
+	Chikou  []float64 `json:"chikou,omitempty"`
+}
+
+type Rsi struct {
+	Period int       `json:"period,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+type Macd struct {
+	FastPeriod   int       `json:"fast_period,omitempty"`
+	SlowPeriod   int       `json:"slow_period,omitempty"`
+	SignalPeriod int       `json:"signal_period,omitempty"`
+	Macd         []float64 `json:"macd,omitempty"`
+	MacdSignal   []float64 `json:"macd_signal,omitempty"`
+	MacdHist     []float64 `json:"macd_hist,omitempty"`
+}
+
+type Hv struct {
+	Period int       `json:"period,omitempty"`
+	Values []float64 `json:"values,omitempty"`
 }
 
 // []Candle配列にデータを格納し、Candle Chartで表示するための設定
@@ -69,3 +132,150 @@ func (df *DataFrameCandle) Volumes() []float64 {
 	}
 	return s
 }
+
+// Candlesをより長い時間足に集計したDataFrameCandleを返す(テクニカル指標は含めない)
+// durationはdf.Durationの倍数を指定する。時刻はdurationで切り捨てて揃えるため、1dはUTCの0時、1wは月曜日の0時(UTC)が始まりとなる
+// 始値は最初、終値は最後のCandleの値、高値と安値は最大値と最小値、出来高は合計とする
+func (df *DataFrameCandle) Resample(duration time.Duration) (*DataFrameCandle, error) {
+	if df.Duration <= 0 || duration < df.Duration || duration%df.Duration != 0 {
+		return nil, fmt.Errorf("cannot resample %s candles into %s", df.Duration, duration)
+	}
+	resampled := &DataFrameCandle{ProductCode: df.ProductCode, Duration: duration}
+	for _, candle := range df.Candles {
+		candleTime := candle.Time.Truncate(duration)
+		last := len(resampled.Candles) - 1
+		if last < 0 || !resampled.Candles[last].Time.Equal(candleTime) {
+			c := candle
+			c.Duration = duration
+			c.Time = candleTime
+			resampled.Candles = append(resampled.Candles, c)
+			continue
+		}
+		c := &resampled.Candles[last]
+		c.High = math.Max(c.High, candle.High)
+		c.Low = math.Min(c.Low, candle.Low)
+		c.Close = candle.Close
+		c.Volume += candle.Volume
+		c.BuyVolume += candle.BuyVolume
+		c.SellVolume += candle.SellVolume
+	}
+	return resampled, nil
+}
+
+// テクニカル指標の取得処理を定義
+// 全ての指標はCandlesと同じ長さのスライスで返し、計算に必要な本数が揃っていない先頭部分は0とする
+
+// 終値の単純移動平均(SMA)を取得
+func (df *DataFrameCandle) Sma(period int) []float64 {
+	return tradingalgo.Sma(df.Closes(), period)
+}
+
+// 終値の指数平滑移動平均(EMA)を取得
+func (df *DataFrameCandle) Ema(period int) []float64 {
+	return tradingalgo.Ema(df.Closes(), period)
+}
+
+// 終値のボリンジャーバンド(n本のSMA±標準偏差のk倍)を取得
+func (df *DataFrameCandle) BBands(n int, k float64) (upper, middle, lower []float64) {
+	return tradingalgo.BBands(df.Closes(), n, k)
+}
+
+// 一目均衡表(転換線, 基準線, 先行スパン1, 先行スパン2, 遅行スパン)を取得
+// 一般的なパラメータは conversion=9, base=26, spanB=52
+func (df *DataFrameCandle) IchimokuCloud(conversion, base, spanB int) (tenkan, kijun, senkouA, senkouB, chikou []float64) {
+	return tradingalgo.IchimokuCloud(df.Highs(), df.Lows(), df.Closes(), conversion, base, spanB)
+}
+
+// 終値の相対力指数(RSI)を取得
+func (df *DataFrameCandle) Rsi(period int) []float64 {
+	return tradingalgo.Rsi(df.Closes(), period)
+}
+
+// 終値のMACD(MACD線, シグナル線, ヒストグラム)を取得
+func (df *DataFrameCandle) Macd(fastPeriod, slowPeriod, signalPeriod int) (macd, signal, hist []float64) {
+	return tradingalgo.Macd(df.Closes(), fastPeriod, slowPeriod, signalPeriod)
+}
+
+// 終値のヒストリカル・ボラティリティ(対数収益率の標準偏差, %)を取得
+func (df *DataFrameCandle) Hv(period int) []float64 {
+	return tradingalgo.Hv(df.Closes(), period)
+}
+
+// APIのレスポンスに含めるテクニカル指標を追加する処理を定義
+// 計算に必要な本数のCandleが揃っていない場合は追加せずにfalseを返す
+
+func (df *DataFrameCandle) AddSma(period int) bool {
+	if period <= 0 || len(df.Candles) < period {
+		return false
+	}
+	df.Smas = append(df.Smas, Sma{Period: period, Values: df.Sma(period)})
+	return true
+}
+
+func (df *DataFrameCandle) AddEma(period int) bool {
+	if period <= 0 || len(df.Candles) < period {
+		return false
+	}
+	df.Emas = append(df.Emas, Ema{Period: period, Values: df.Ema(period)})
+	return true
+}
+
+func (df *DataFrameCandle) AddBBands(n int, k float64) bool {
+	if n <= 0 || len(df.Candles) < n {
+		return false
+	}
+	up, mid, down := df.BBands(n, k)
+	df.Indicators.BBands = &BBands{N: n, K: k, Up: up, Mid: mid, Down: down}
+	return true
+}
+
+func (df *DataFrameCandle) AddIchimokuCloud(conversion, base, spanB int) bool {
+	if conversion <= 0 || base <= 0 || spanB <= 0 || len(df.Candles) < spanB {
+		return false
+	}
+	tenkan, kijun, senkouA, senkouB, chikou := df.IchimokuCloud(conversion, base, spanB)
+	df.Indicators.IchimokuCloud = &IchimokuCloud{
+		Tenkan:  tenkan,
+		Kijun:   kijun,
+		SenkouA: senkouA,
+		SenkouB: senkouB,
+		Chikou:  chikou,
+	}
+	return true
+}
+
+func (df *DataFrameCandle) AddRsi(period int) bool {
+	if period <= 0 || len(df.Candles) <= period {
+		return false
+	}
+	df.Indicators.Rsi = &Rsi{Period: period, Values: df.Rsi(period)}
+	return true
+}
+
+// 短期と長期の期間が逆に指定された場合は入れ替えてから、長期の期間で必要な本数を判定する
+func (df *DataFrameCandle) AddMacd(fastPeriod, slowPeriod, signalPeriod int) bool {
+	if fastPeriod > slowPeriod {
+		fastPeriod, slowPeriod = slowPeriod, fastPeriod
+	}
+	if fastPeriod <= 0 || slowPeriod <= 0 || signalPeriod <= 0 || len(df.Candles) < slowPeriod+signalPeriod-1 {
+		return false
+	}
+	macd, macdSignal, macdHist := df.Macd(fastPeriod, slowPeriod, signalPeriod)
+	df.Indicators.Macd = &Macd{
+		FastPeriod:   fastPeriod,
+		SlowPeriod:   slowPeriod,
+		SignalPeriod: signalPeriod,
+		Macd:         macd,
+		MacdSignal:   macdSignal,
+		MacdHist:     macdHist,
+	}
+	return true
+}
+
+func (df *DataFrameCandle) AddHv(period int) bool {
+	if period <= 0 || len(df.Candles) <= period {
+		return false
+	}
+	df.Hvs = append(df.Hvs, Hv{Period: period, Values: df.Hv(period)})
+	return true
+}
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 終値のみを指定したDataFrameCandleを生成する(始値・高値・安値は終値と同じ値とする)
+func newTestDataFrame(closes ...float64) *DataFrameCandle {
+	df := &DataFrameCandle{ProductCode: "BTC_JPY", Duration: time.Minute}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, price := range closes {
+		df.Candles = append(df.Candles, *NewCandle(df.ProductCode, df.Duration, start.Add(time.Duration(i)*df.Duration),
+			price, price, price, price, 1))
+	}
+	return df
+}
+
+func assertFloats(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: len = %d, want %d (%v)", name, len(got), len(want), got)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("%s[%d] = %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestSma(t *testing.T) {
+	tests := []struct {
+		name   string
+		closes []float64
+		period int
+		want   []float64
+	}{
+		{"period 3", []float64{1, 2, 3, 4, 5}, 3, []float64{0, 0, 2, 3, 4}},
+		{"period 1", []float64{1, 2, 3}, 1, []float64{1, 2, 3}},
+		{"period equals length", []float64{1, 2, 6}, 3, []float64{0, 0, 3}},
+		{"period longer than candles", []float64{1, 2, 3}, 4, []float64{0, 0, 0}},
+		{"zero period", []float64{1, 2, 3}, 0, []float64{0, 0, 0}},
+		{"negative period", []float64{1, 2, 3}, -1, []float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFloats(t, "sma", newTestDataFrame(tt.closes...).Sma(tt.period), tt.want)
+		})
+	}
+}
+
+func TestEma(t *testing.T) {
+	tests := []struct {
+		name   string
+		closes []float64
+		period int
+		want   []float64
+	}{
+		// 最初の値は先頭3本のSMA(11)、以降は 2/(3+1) = 0.5 の重みで平滑化する
+		{"period 3", []float64{10, 11, 12, 10, 14}, 3, []float64{0, 0, 11, 10.5, 12.25}},
+		{"period 1", []float64{10, 11, 12}, 1, []float64{10, 11, 12}},
+		{"period longer than candles", []float64{10, 11}, 3, []float64{0, 0}},
+		{"zero period", []float64{10, 11}, 0, []float64{0, 0}},
+		{"negative period", []float64{10, 11}, -3, []float64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFloats(t, "ema", newTestDataFrame(tt.closes...).Ema(tt.period), tt.want)
+		})
+	}
+}
+
+func TestBBands(t *testing.T) {
+	// [1, 2, 3] の母標準偏差は sqrt(2/3)
+	dev := 2 * math.Sqrt(2.0/3.0)
+	tests := []struct {
+		name                      string
+		closes                    []float64
+		n                         int
+		k                         float64
+		wantUp, wantMid, wantDown []float64
+	}{
+		{"n 3 k 2", []float64{1, 2, 3, 4}, 3, 2,
+			[]float64{0, 0, 2 + dev, 3 + dev}, []float64{0, 0, 2, 3}, []float64{0, 0, 2 - dev, 3 - dev}},
+		{"flat prices", []float64{5, 5, 5}, 2, 2,
+			[]float64{0, 5, 5}, []float64{0, 5, 5}, []float64{0, 5, 5}},
+		{"n longer than candles", []float64{1, 2}, 3, 2,
+			[]float64{0, 0}, []float64{0, 0}, []float64{0, 0}},
+		{"zero n", []float64{1, 2}, 0, 2,
+			[]float64{0, 0}, []float64{0, 0}, []float64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, mid, down := newTestDataFrame(tt.closes...).BBands(tt.n, tt.k)
+			assertFloats(t, "up", up, tt.wantUp)
+			assertFloats(t, "mid", mid, tt.wantMid)
+			assertFloats(t, "down", down, tt.wantDown)
+		})
+	}
+}
+
+func TestIchimokuCloud(t *testing.T) {
+	df := newTestDataFrame(9, 11, 10, 12, 14, 13)
+	highs := []float64{10, 12, 11, 13, 15, 14}
+	lows := []float64{8, 9, 7, 10, 12, 11}
+	for i := range df.Candles {
+		df.Candles[i].High = highs[i]
+		df.Candles[i].Low = lows[i]
+	}
+
+	tenkan, kijun, senkouA, senkouB, chikou := df.IchimokuCloud(2, 2, 3)
+	assertFloats(t, "tenkan", tenkan, []float64{0, 10, 9.5, 10, 12.5, 13})
+	assertFloats(t, "kijun", kijun, []float64{0, 10, 9.5, 10, 12.5, 13})
+	// 先行スパンは基準線の期間(2本)だけ先にずらす
+	assertFloats(t, "senkouA", senkouA, []float64{0, 0, 0, 10, 9.5, 10})
+	assertFloats(t, "senkouB", senkouB, []float64{0, 0, 0, 0, 9.5, 10})
+	assertFloats(t, "chikou", chikou, []float64{10, 12, 14, 13, 0, 0})
+
+	t.Run("periods longer than candles", func(t *testing.T) {
+		tenkan, kijun, senkouA, senkouB, _ := newTestDataFrame(1, 2).IchimokuCloud(9, 26, 52)
+		for name, values := range map[string][]float64{"tenkan": tenkan, "kijun": kijun, "senkouA": senkouA, "senkouB": senkouB} {
+			assertFloats(t, name, values, []float64{0, 0})
+		}
+	})
+	t.Run("zero period", func(t *testing.T) {
+		tenkan, kijun, senkouA, senkouB, chikou := df.IchimokuCloud(0, 0, 0)
+		zeros := make([]float64, len(df.Candles))
+		for name, values := range map[string][]float64{"tenkan": tenkan, "kijun": kijun, "senkouA": senkouA, "senkouB": senkouB, "chikou": chikou} {
+			assertFloats(t, name, values, zeros)
+		}
+	})
+}
+
+func TestRsi(t *testing.T) {
+	tests := []struct {
+		name   string
+		closes []float64
+		period int
+		want   []float64
+	}{
+		// 最初の平均: 上昇幅1, 下落幅0 => 100, 以降はワイルダーの平滑化 => 50, 75
+		{"period 2", []float64{1, 2, 3, 2, 3}, 2, []float64{0, 0, 100, 50, 75}},
+		{"flat prices", []float64{3, 3, 3}, 2, []float64{0, 0, 50}},
+		{"only falling", []float64{3, 2, 1}, 2, []float64{0, 0, 0}},
+		{"period equals length", []float64{1, 2, 3}, 3, []float64{0, 0, 0}},
+		{"period longer than candles", []float64{1, 2, 3}, 5, []float64{0, 0, 0}},
+		{"zero period", []float64{1, 2, 3}, 0, []float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFloats(t, "rsi", newTestDataFrame(tt.closes...).Rsi(tt.period), tt.want)
+		})
+	}
+}
+
+func TestMacd(t *testing.T) {
+	closes := []float64{1, 2, 4, 7, 11, 16}
+	tests := []struct {
+		name                           string
+		fast, slow, signal             int
+		wantMacd, wantSignal, wantHist []float64
+	}{
+		// EMA(2) - EMA(3) をシグナル線(EMA(2))が計算できる4本目から返す
+		{"fast 2 slow 3 signal 2", 2, 3, 2,
+			[]float64{0, 0, 0, 19.0 / 18, 38.0 / 27, 593.0 / 324},
+			[]float64{0, 0, 0, 17.0 / 18, 203.0 / 162, 398.0 / 243},
+			[]float64{0, 0, 0, 1.0 / 9, 25.0 / 162, 187.0 / 972}},
+		{"reversed periods", 3, 2, 2,
+			[]float64{0, 0, 0, 19.0 / 18, 38.0 / 27, 593.0 / 324},
+			[]float64{0, 0, 0, 17.0 / 18, 203.0 / 162, 398.0 / 243},
+			[]float64{0, 0, 0, 1.0 / 9, 25.0 / 162, 187.0 / 972}},
+		{"periods longer than candles", 12, 26, 9,
+			make([]float64, 6), make([]float64, 6), make([]float64, 6)},
+		{"zero period", 0, 3, 2,
+			make([]float64, 6), make([]float64, 6), make([]float64, 6)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			macd, signal, hist := newTestDataFrame(closes...).Macd(tt.fast, tt.slow, tt.signal)
+			assertFloats(t, "macd", macd, tt.wantMacd)
+			assertFloats(t, "signal", signal, tt.wantSignal)
+			assertFloats(t, "hist", hist, tt.wantHist)
+		})
+	}
+}
+
+func TestHv(t *testing.T) {
+	// 対数収益率 ln(1.1), ln(0.9) の母標準偏差(%)
+	a, b := math.Log(1.1), math.Log(0.9)
+	hv := math.Abs(a-b) / 2 * 100
+	tests := []struct {
+		name   string
+		closes []float64
+		period int
+		want   []float64
+	}{
+		{"period 2", []float64{100, 110, 99}, 2, []float64{0, 0, hv}},
+		{"period 1", []float64{100, 110, 99}, 1, []float64{0, 0, 0}},
+		{"period equals length", []float64{100, 110, 99}, 3, []float64{0, 0, 0}},
+		{"period longer than candles", []float64{100, 110}, 5, []float64{0, 0}},
+		{"zero period", []float64{100, 110}, 0, []float64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFloats(t, "hv", newTestDataFrame(tt.closes...).Hv(tt.period), tt.want)
+		})
+	}
+}
+
+// APIのレスポンスに追加する指標は、計算に必要な本数が揃っていない場合と期間が不正な場合に追加しない
+func TestAddIndicators(t *testing.T) {
+	tests := []struct {
+		name string
+		add  func(df *DataFrameCandle) bool
+		want bool
+	}{
+		{"sma", func(df *DataFrameCandle) bool { return df.AddSma(5) }, true},
+		{"sma longer than candles", func(df *DataFrameCandle) bool { return df.AddSma(6) }, false},
+		{"sma zero period", func(df *DataFrameCandle) bool { return df.AddSma(0) }, false},
+		{"ema", func(df *DataFrameCandle) bool { return df.AddEma(5) }, true},
+		{"ema longer than candles", func(df *DataFrameCandle) bool { return df.AddEma(6) }, false},
+		{"ema negative period", func(df *DataFrameCandle) bool { return df.AddEma(-1) }, false},
+		{"bbands", func(df *DataFrameCandle) bool { return df.AddBBands(5, 2) }, true},
+		{"bbands longer than candles", func(df *DataFrameCandle) bool { return df.AddBBands(6, 2) }, false},
+		{"bbands zero n", func(df *DataFrameCandle) bool { return df.AddBBands(0, 2) }, false},
+		{"ichimoku", func(df *DataFrameCandle) bool { return df.AddIchimokuCloud(2, 3, 5) }, true},
+		{"ichimoku longer than candles", func(df *DataFrameCandle) bool { return df.AddIchimokuCloud(2, 3, 6) }, false},
+		{"ichimoku zero period", func(df *DataFrameCandle) bool { return df.AddIchimokuCloud(0, 3, 5) }, false},
+		{"rsi", func(df *DataFrameCandle) bool { return df.AddRsi(4) }, true},
+		{"rsi equals candles", func(df *DataFrameCandle) bool { return df.AddRsi(5) }, false},
+		{"rsi zero period", func(df *DataFrameCandle) bool { return df.AddRsi(0) }, false},
+		{"macd", func(df *DataFrameCandle) bool { return df.AddMacd(2, 3, 3) }, true},
+		{"macd longer than candles", func(df *DataFrameCandle) bool { return df.AddMacd(2, 4, 3) }, false},
+		{"macd zero period", func(df *DataFrameCandle) bool { return df.AddMacd(2, 3, 0) }, false},
+		{"macd reversed periods", func(df *DataFrameCandle) bool { return df.AddMacd(3, 2, 3) }, true},
+		{"macd reversed periods longer than candles", func(df *DataFrameCandle) bool { return df.AddMacd(4, 2, 3) }, false},
+		{"hv", func(df *DataFrameCandle) bool { return df.AddHv(4) }, true},
+		{"hv equals candles", func(df *DataFrameCandle) bool { return df.AddHv(5) }, false},
+		{"hv zero period", func(df *DataFrameCandle) bool { return df.AddHv(0) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.add(newTestDataFrame(1, 2, 3, 4, 5)); got != tt.want {
+				t.Errorf("added = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Candleのテーブルで連続して欠けている期間を定義
+// 約定履歴から生成する場合は約定がなかった期間のCandleも欠けるため、取り込みが止まっていたとは限らない
+type CandleGap struct {
+	ProductCode string        `json:"product_code"`
+	Duration    time.Duration `json:"duration"`
+	From        time.Time     `json:"from"` // 最初に欠けているCandleの時刻
+	To          time.Time     `json:"to"`   // 最後に欠けているCandleの時刻
+	Missing     int           `json:"missing"`
+}
+
+// sinceからuntilまでの間でCandleが欠けている期間を古い順に返す
+// 最後のCandleからuntilまでの期間も含めるため、untilには最後に完成したCandleの時刻を指定する
+// since以降の最初のCandleまでの期間も含め、期間内にCandleが1本もない場合は期間全体を欠損とする
+func FindCandleGaps(productCode string, duration time.Duration, since, until time.Time) ([]CandleGap, error) {
+	// 書き込み中のCandleを見落とさないよう、書き込みが終わるまで待ってから読み込む
+	candles.flushMu.RLock()
+	defer candles.flushMu.RUnlock()
+
+	tableName := GetCandleTableName(productCode, duration)
+	cmd := fmt.Sprintf("SELECT time FROM %s WHERE time >= ? ORDER BY time ASC", tableName)
+	rows, err := DbConnection.Query(cmd, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stored []Candle
+	for rows.Next() {
+		var candle Candle
+		if err := rows.Scan(&candle.Time); err != nil {
+			return nil, err
+		}
+		stored = append(stored, candle)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// メモリに保持している未書き込みのCandleがある時刻は欠損としない
+	var unsaved []Candle
+	for _, candle := range candles.unsaved(tableName) {
+		if !candle.Time.Before(since) {
+			unsaved = append(unsaved, candle)
+		}
+	}
+	merged := mergeCandles(stored, unsaved, len(stored)+len(unsaved))
+
+	var gaps []CandleGap
+	addGap := func(from, to time.Time) {
+		if to.Before(from) {
+			return
+		}
+		gaps = append(gaps, CandleGap{
+			ProductCode: productCode,
+			Duration:    duration,
+			From:        from,
+			To:          to,
+			Missing:     int(to.Sub(from)/duration) + 1,
+		})
+	}
+
+	// since以降で最初のCandleの時刻の1本前から始めることで、最初のCandleまでの欠損も検出する
+	start := since.UTC().Truncate(duration)
+	if start.Before(since) {
+		start = start.Add(duration)
+	}
+	previous := start.Add(-duration)
+	for _, candle := range merged {
+		if candle.Time.Sub(previous) > duration {
+			addGap(previous.Add(duration), candle.Time.Add(-duration))
+		}
+		previous = candle.Time
+	}
+	addGap(previous.Add(duration), until.UTC().Truncate(duration))
+	return gaps, nil
+}
+
+// 欠けている期間を直前のCandleの終値で埋める(始値・高値・安値・終値が同じで出来高0のCandleを追加する)
+// 既にCandleがある時刻は変更しないため、何度実行しても結果は変わらない
+// メモリに保持している未書き込みのCandleは先に書き込むため、取り込んだCandleを補完したCandleで置き換えることはない
+func FillCandleGap(gap CandleGap) (int, error) {
+	tableName := GetCandleTableName(gap.ProductCode, gap.Duration)
+	cmd := fmt.Sprintf(`INSERT OR IGNORE INTO %s (time, open, close, high, low, volume, buy_volume, sell_volume)
+		VALUES (?, ?, ?, ?, ?, 0, 0, 0)`, tableName)
+
+	filled := 0
+	err := candles.writeThrough(tableName, gap.From, gap.To, func() error {
+		previous := GetCandle(gap.ProductCode, gap.Duration, gap.From.Add(-gap.Duration))
+		if previous == nil {
+			return fmt.Errorf("no candle before the gap: product_code=%s duration=%s from=%s", gap.ProductCode, gap.Duration, gap.From)
+		}
+
+		tx, err := DbConnection.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		price := previous.Close
+		for candleTime := gap.From; !candleTime.After(gap.To); candleTime = candleTime.Add(gap.Duration) {
+			result, err := tx.Exec(cmd, candleTime.Format(time.RFC3339), price, price, price, price)
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				filled++
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return filled, nil
+}
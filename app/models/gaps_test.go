@@ -0,0 +1,76 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindCandleGaps(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	minute := func(n int) time.Time { return base.Add(time.Duration(n) * time.Minute) }
+	type gap struct {
+		from, to time.Time
+		missing  int
+	}
+	tests := []struct {
+		name    string
+		candles []time.Time
+		since   time.Time
+		want    []gap
+	}{
+		{"no candles in the window", nil, base,
+			[]gap{{minute(0), minute(9), 10}}},
+		{"leading, middle and trailing gaps", []time.Time{minute(3), minute(4), minute(7)}, base,
+			[]gap{{minute(0), minute(2), 3}, {minute(5), minute(6), 2}, {minute(8), minute(9), 2}}},
+		{"complete", []time.Time{minute(0), minute(1), minute(2), minute(3), minute(4), minute(5), minute(6), minute(7), minute(8), minute(9)}, base,
+			nil},
+		{"candles before since are ignored", []time.Time{minute(-5), minute(9)}, base,
+			[]gap{{minute(0), minute(8), 9}}},
+		{"since in the middle of a candle", []time.Time{minute(2), minute(9)}, base.Add(30 * time.Second),
+			[]gap{{minute(1), minute(1), 1}, {minute(3), minute(8), 6}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCandles(t, "BTC_JPY", time.Minute)
+			insertCandles(t, "BTC_JPY", time.Minute, tt.candles...)
+
+			gaps, err := FindCandleGaps("BTC_JPY", time.Minute, tt.since, minute(9))
+			if err != nil {
+				t.Fatalf("FindCandleGaps: %v", err)
+			}
+			if len(gaps) != len(tt.want) {
+				t.Fatalf("gaps = %+v, want %+v", gaps, tt.want)
+			}
+			for i, want := range tt.want {
+				got := gaps[i]
+				if !got.From.Equal(want.from) || !got.To.Equal(want.to) || got.Missing != want.missing {
+					t.Errorf("gaps[%d] = %s..%s (%d), want %s..%s (%d)", i,
+						got.From.Format(time.RFC3339), got.To.Format(time.RFC3339), got.Missing,
+						want.from.Format(time.RFC3339), want.to.Format(time.RFC3339), want.missing)
+				}
+			}
+		})
+	}
+}
+
+// 欠損を埋めたあとは欠損として検出されず、既にあるCandleは変更しない
+func TestFillCandleGap(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	insertCandles(t, "BTC_JPY", time.Minute, base, base.Add(4*time.Minute))
+
+	gaps, err := FindCandleGaps("BTC_JPY", time.Minute, base, base.Add(4*time.Minute))
+	if err != nil || len(gaps) != 1 {
+		t.Fatalf("gaps = %+v err = %v, want 1 gap", gaps, err)
+	}
+	filled, err := FillCandleGap(gaps[0])
+	if err != nil || filled != 3 {
+		t.Fatalf("filled = %d err = %v, want 3", filled, err)
+	}
+	if candle := GetCandle("BTC_JPY", time.Minute, base.Add(2*time.Minute)); candle == nil || candle.Close != 100 || candle.Volume != 0 {
+		t.Errorf("filled candle = %+v, want close 100 volume 0", candle)
+	}
+	if gaps, _ := FindCandleGaps("BTC_JPY", time.Minute, base, base.Add(4*time.Minute)); len(gaps) != 0 {
+		t.Errorf("gaps after fill = %+v, want none", gaps)
+	}
+}
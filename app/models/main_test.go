@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 一時ディレクトリのデータベースにテーブルを作り直してからテストを実行する
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gotrading")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	DbConnection.Close()
+	DbConnection, err = sql.Open("sqlite3", filepath.Join(dir, "stockdata.sql"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	createTables()
+
+	code := m.Run()
+	DbConnection.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Candleのテーブルとメモリに保持しているCandleを空にする
+func resetCandles(t *testing.T, productCode string, duration time.Duration) {
+	t.Helper()
+	candles = newCandleCache()
+	if _, err := DbConnection.Exec("DELETE FROM " + GetCandleTableName(productCode, duration)); err != nil {
+		t.Fatalf("reset %s: %v", GetCandleTableName(productCode, duration), err)
+	}
+}
+
+// 指定した時刻のCandleをデータベースに直接追加する
+func insertCandles(t *testing.T, productCode string, duration time.Duration, times ...time.Time) {
+	t.Helper()
+	for _, candleTime := range times {
+		if err := NewCandle(productCode, duration, candleTime, 100, 100, 100, 100, 1).Create(); err != nil {
+			t.Fatalf("insert %s: %v", candleTime, err)
+		}
+	}
+}
+
+// 売買の記録を空にする
+func resetSignalEvents(t *testing.T) {
+	t.Helper()
+	if _, err := DbConnection.Exec("DELETE FROM " + tableNameSignalEvents); err != nil {
+		t.Fatalf("reset %s: %v", tableNameSignalEvents, err)
+	}
+}
+
+// DataFrameCandleのCandleをそのままデータベースに追加する
+func insertDataFrame(t *testing.T, df *DataFrameCandle) {
+	t.Helper()
+	for _, candle := range df.Candles {
+		if err := candle.Create(); err != nil {
+			t.Fatalf("insert %s: %v", candle.Time, err)
+		}
+	}
+}
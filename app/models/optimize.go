@@ -0,0 +1,143 @@
+package models
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 戦略名と、グリッドサーチで評価するパラメータの候補を生成する関数の対応表
+var strategyParamGrids = map[string]func() []Strategy{
+	"breakout": func() []Strategy {
+		var candidates []Strategy
+		for period := 5; period <= 60; period++ {
+			candidates = append(candidates, &BreakoutStrategy{Period: period})
+		}
+		return candidates
+	},
+	"ema": func() []Strategy {
+		var candidates []Strategy
+		for short := 3; short <= 20; short++ {
+			for long := short + 1; long <= 60; long++ {
+				candidates = append(candidates, &EmaStrategy{ShortPeriod: short, LongPeriod: long})
+			}
+		}
+		return candidates
+	},
+	"bbands": func() []Strategy {
+		var candidates []Strategy
+		for n := 10; n <= 40; n++ {
+			for k := 1.0; k <= 3.0; k += 0.5 {
+				candidates = append(candidates, &BBandsStrategy{N: n, K: k})
+			}
+		}
+		return candidates
+	},
+	"ichimoku": func() []Strategy {
+		var candidates []Strategy
+		for _, conversion := range []int{7, 9, 12} {
+			for _, base := range []int{22, 26, 30} {
+				for _, spanB := range []int{44, 52, 60} {
+					candidates = append(candidates, &IchimokuStrategy{Conversion: conversion, Base: base, SpanB: spanB})
+				}
+			}
+		}
+		return candidates
+	},
+	"rsi": func() []Strategy {
+		var candidates []Strategy
+		for period := 5; period <= 30; period++ {
+			for buyThreshold := 20.0; buyThreshold <= 40; buyThreshold += 5 {
+				for sellThreshold := 60.0; sellThreshold <= 80; sellThreshold += 5 {
+					candidates = append(candidates, &RsiStrategy{Period: period, BuyThreshold: buyThreshold, SellThreshold: sellThreshold})
+				}
+			}
+		}
+		return candidates
+	},
+	"macd": func() []Strategy {
+		var candidates []Strategy
+		for fast := 5; fast <= 15; fast++ {
+			for slow := 20; slow <= 35; slow++ {
+				for signal := 5; signal <= 12; signal++ {
+					candidates = append(candidates, &MacdStrategy{FastPeriod: fast, SlowPeriod: slow, SignalPeriod: signal})
+				}
+			}
+		}
+		return candidates
+	},
+}
+
+// DBに保存されているCandleを使用して、指定した戦略(省略時は全ての戦略)のパラメータを最適化する処理を定義
+func RunOptimize(productCode string, duration time.Duration, limit int, names ...string) (map[string]*BackTestResult, error) {
+	df, err := GetAllCandle(productCode, duration, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		names = StrategyNames()
+	}
+
+	results := make(map[string]*BackTestResult, len(names))
+	for _, name := range names {
+		result, err := df.Optimize(name)
+		if err != nil {
+			return nil, err
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+// 指定した戦略のパラメータの候補を全てバックテストし、最も損益が大きかった結果を返す処理を定義
+// バックテストはCPUのコア数と同じ数のgoroutineで並行して実行する
+func (df *DataFrameCandle) Optimize(name string) (*BackTestResult, error) {
+	paramGrid, ok := strategyParamGrids[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy: %s", name)
+	}
+	candidates := paramGrid()
+
+	// 各goroutineは候補のインデックスを受け取り、結果を同じインデックスに格納する
+	indexCh := make(chan int)
+	results := make([]*BackTestResult, len(candidates))
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexCh {
+				results[index] = df.BackTest(candidates[index])
+			}
+		}()
+	}
+	for index := range candidates {
+		indexCh <- index
+	}
+	close(indexCh)
+	wg.Wait()
+
+	// 評価が同じ場合は候補の順番が先のものを採用する
+	var best *BackTestResult
+	for _, result := range results {
+		if best == nil || result.BetterThan(best) {
+			best = result
+		}
+	}
+	return best, nil
+}
+
+// 損益が大きい方を優先し、同じ場合はシャープレシオが高い方を優先する
+func (r *BackTestResult) BetterThan(other *BackTestResult) bool {
+	if r.Profit != other.Profit {
+		return r.Profit > other.Profit
+	}
+	return r.SharpeRatio > other.SharpeRatio
+}
+
+// 最適化の結果を現在のパラメータの代わりに採用するかどうか
+// 売買が1度もない場合や利益が出ていない場合、現在のパラメータのバックテスト結果を上回らない場合は採用しない
+func (r *BackTestResult) ShouldReplace(current *BackTestResult) bool {
+	return r.Trades > 0 && r.Profit > 0 && r.BetterThan(current)
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 上昇しながら上下に振動する終値のDataFrameCandleを生成する(ブレイクアウトで売買が発生する)
+func newWaveDataFrame(n int) *DataFrameCandle {
+	closes := make([]float64, n)
+	for i := range closes {
+		closes[i] = 100 + 10*math.Sin(float64(i)/4) + float64(i)*0.2
+	}
+	return newTestDataFrame(closes...)
+}
+
+func TestBetterThan(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     BackTestResult
+		other BackTestResult
+		want  bool
+	}{
+		{"higher profit", BackTestResult{Profit: 10, SharpeRatio: 0.1}, BackTestResult{Profit: 5, SharpeRatio: 2}, true},
+		{"lower profit", BackTestResult{Profit: 5, SharpeRatio: 2}, BackTestResult{Profit: 10, SharpeRatio: 0.1}, false},
+		{"same profit, higher sharpe ratio", BackTestResult{Profit: 10, SharpeRatio: 2}, BackTestResult{Profit: 10, SharpeRatio: 1}, true},
+		{"same profit, lower sharpe ratio", BackTestResult{Profit: 10, SharpeRatio: 1}, BackTestResult{Profit: 10, SharpeRatio: 2}, false},
+		{"same result", BackTestResult{Profit: 10, SharpeRatio: 1}, BackTestResult{Profit: 10, SharpeRatio: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.BetterThan(&tt.other); got != tt.want {
+				t.Errorf("BetterThan = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 最適化の結果が売買を行い、利益を出し、現在のパラメータを上回る場合のみ採用する
+func TestShouldReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		best    BackTestResult
+		current BackTestResult
+		want    bool
+	}{
+		{"better and profitable", BackTestResult{Trades: 3, Profit: 10}, BackTestResult{Trades: 2, Profit: 5}, true},
+		{"current has no trades", BackTestResult{Trades: 1, Profit: 1}, BackTestResult{}, true},
+		{"same profit, higher sharpe ratio", BackTestResult{Trades: 3, Profit: 10, SharpeRatio: 2}, BackTestResult{Trades: 3, Profit: 10, SharpeRatio: 1}, true},
+		{"no trades", BackTestResult{Trades: 0, Profit: 0}, BackTestResult{Trades: 1, Profit: -5}, false},
+		{"no profit", BackTestResult{Trades: 2, Profit: 0}, BackTestResult{Trades: 1, Profit: -5}, false},
+		{"loss", BackTestResult{Trades: 2, Profit: -1}, BackTestResult{Trades: 1, Profit: -5}, false},
+		{"not better than current", BackTestResult{Trades: 2, Profit: 10}, BackTestResult{Trades: 2, Profit: 10}, false},
+		{"current is better", BackTestResult{Trades: 2, Profit: 10}, BackTestResult{Trades: 4, Profit: 20}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.best.ShouldReplace(&tt.current); got != tt.want {
+				t.Errorf("ShouldReplace = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	df := newWaveDataFrame(200)
+	if _, err := df.Optimize("unknown"); err == nil {
+		t.Errorf("Optimize unknown strategy: err = nil, want error")
+	}
+
+	best, err := df.Optimize("breakout")
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if _, ok := best.Params.(*BreakoutStrategy); !ok || best.Strategy != "breakout" {
+		t.Fatalf("best = %s %T, want breakout", best.Strategy, best.Params)
+	}
+	if best.Trades == 0 || best.Profit <= 0 {
+		t.Fatalf("best = profit %v trades %d, want a profitable result", best.Profit, best.Trades)
+	}
+
+	// 全ての候補を順番にバックテストした場合と同じ結果(評価が同じ場合は先の候補)になる
+	var want *BackTestResult
+	for _, candidate := range strategyParamGrids["breakout"]() {
+		result := df.BackTest(candidate)
+		if result.BetterThan(best) {
+			t.Errorf("candidate %+v profit %v is better than best %+v profit %v", candidate, result.Profit, best.Params, best.Profit)
+		}
+		if want == nil || result.BetterThan(want) {
+			want = result
+		}
+	}
+	if !reflect.DeepEqual(best.Params, want.Params) || best.Profit != want.Profit {
+		t.Errorf("best = %+v profit %v, want %+v profit %v", best.Params, best.Profit, want.Params, want.Profit)
+	}
+
+	// 初期値のパラメータは損失が出るため、最適化したパラメータが採用される
+	current := df.BackTest(&BreakoutStrategy{Period: 20})
+	if current.Profit >= 0 || !best.ShouldReplace(current) {
+		t.Errorf("best profit %v does not replace current profit %v", best.Profit, current.Profit)
+	}
+	// 最適な結果自身を現在のパラメータとした場合は切り替えない
+	if best.ShouldReplace(df.BackTest(best.Params)) {
+		t.Errorf("best replaced the same params")
+	}
+}
+
+// 価格が変動しないCandleでは売買が発生しないため、最適化の結果は採用されない
+func TestRunOptimize(t *testing.T) {
+	resetCandles(t, "BTC_JPY", time.Minute)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var times []time.Time
+	for i := 0; i < 80; i++ {
+		times = append(times, start.Add(time.Duration(i)*time.Minute))
+	}
+	insertCandles(t, "BTC_JPY", time.Minute, times...)
+
+	if _, err := RunOptimize("BTC_JPY", time.Minute, 100, "unknown"); err == nil {
+		t.Errorf("RunOptimize unknown strategy: err = nil, want error")
+	}
+
+	results, err := RunOptimize("BTC_JPY", time.Minute, 100)
+	if err != nil {
+		t.Fatalf("RunOptimize: %v", err)
+	}
+	if len(results) != len(StrategyNames()) {
+		t.Errorf("results = %d, want %d", len(results), len(StrategyNames()))
+	}
+	df, err := GetAllCandle("BTC_JPY", time.Minute, 100)
+	if err != nil {
+		t.Fatalf("GetAllCandle: %v", err)
+	}
+	for _, name := range StrategyNames() {
+		best, ok := results[name]
+		if !ok {
+			t.Errorf("%s: no result", name)
+			continue
+		}
+		if best.Strategy != name || best.Trades != 0 || best.Profit != 0 {
+			t.Errorf("%s: best = %s profit %v trades %d, want no trades", name, best.Strategy, best.Profit, best.Trades)
+		}
+		current, err := NewStrategy(name)
+		if err != nil {
+			t.Fatalf("NewStrategy: %v", err)
+		}
+		if best.ShouldReplace(df.BackTest(current)) {
+			t.Errorf("%s: best %+v replaced current params %+v", name, best.Params, current)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// 売買の記録(signal_eventsテーブルの1行)の構造体を定義
+type SignalEvent struct {
+	Time        time.Time `json:"time"`
+	ProductCode string    `json:"product_code"`
+	Side        string    `json:"side"`
+	Price       float64   `json:"price"`
+	Size        float64   `json:"size"`
+}
+
+// SQLクエリを発行してsignal_eventsテーブルにレコードを追加する処理を定義
+func (s *SignalEvent) Save() error {
+	cmd := fmt.Sprintf("INSERT INTO %s (time, product_code, side, price, size) VALUES (?, ?, ?, ?, ?)", tableNameSignalEvents)
+	_, err := DbConnection.Exec(cmd, s.Time.Format(time.RFC3339), s.ProductCode, s.Side, s.Price, s.Size)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// 売買の記録を時系列順(昇順)にまとめて扱う構造体を定義
+type SignalEvents struct {
+	Signals []SignalEvent `json:"signals,omitempty"`
+}
+
+// 空のSignalEventsを生成するコンストラクタ
+func NewSignalEvents() *SignalEvents {
+	return &SignalEvents{}
+}
+
+// 指定したproduct_codeの直近loadEvents件の売買の記録を取得する処理を定義
+func GetSignalEventsByProductCode(productCode string, loadEvents int) (*SignalEvents, error) {
+	cmd := fmt.Sprintf(`SELECT * FROM (
+		SELECT time, product_code, side, price, size FROM %s WHERE product_code = ? ORDER BY time DESC LIMIT ?
+		) ORDER BY time ASC;`, tableNameSignalEvents)
+	return querySignalEvents(cmd, productCode, loadEvents)
+}
+
+// 指定したproduct_codeの指定時刻以降の売買の記録を全て取得する処理を定義
+func GetSignalEventsAfterTime(productCode string, timeTime time.Time) (*SignalEvents, error) {
+	cmd := fmt.Sprintf(`SELECT time, product_code, side, price, size FROM %s
+		WHERE product_code = ? AND DATETIME(time) >= DATETIME(?) ORDER BY time ASC;`, tableNameSignalEvents)
+	return querySignalEvents(cmd, productCode, timeTime.Format(time.RFC3339))
+}
+
+// SELECT文を実行して取得したレコードをSignalEventsに格納する処理を定義
+func querySignalEvents(cmd string, args ...interface{}) (*SignalEvents, error) {
+	rows, err := DbConnection.Query(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signalEvents := NewSignalEvents()
+	for rows.Next() {
+		var signalEvent SignalEvent
+		if err := rows.Scan(&signalEvent.Time, &signalEvent.ProductCode, &signalEvent.Side, &signalEvent.Price, &signalEvent.Size); err != nil {
+			return nil, err
+		}
+		signalEvents.Signals = append(signalEvents.Signals, signalEvent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return signalEvents, nil
+}
+
+// 購入できるかを判定(最後の記録がSELLで、且つ指定時刻より前の場合のみ購入可能)
+func (s *SignalEvents) CanBuy(timeTime time.Time) bool {
+	lenSignals := len(s.Signals)
+	if lenSignals == 0 {
+		return true
+	}
+
+	lastSignal := s.Signals[lenSignals-1]
+	if lastSignal.Side == SignalSell && lastSignal.Time.Before(timeTime) {
+		return true
+	}
+	return false
+}
+
+// 売却できるかを判定(最後の記録がBUYで、且つ指定時刻より前の場合のみ売却可能)
+func (s *SignalEvents) CanSell(timeTime time.Time) bool {
+	lenSignals := len(s.Signals)
+	if lenSignals == 0 {
+		return false
+	}
+
+	lastSignal := s.Signals[lenSignals-1]
+	if lastSignal.Side == SignalBuy && lastSignal.Time.Before(timeTime) {
+		return true
+	}
+	return false
+}
+
+// 購入の記録を追加する処理を定義(saveがtrueの場合はDBにも保存する)
+func (s *SignalEvents) Buy(productCode string, timeTime time.Time, price, size float64, save bool) bool {
+	if !s.CanBuy(timeTime) {
+		return false
+	}
+	return s.addSignal(SignalEvent{
+		Time:        timeTime,
+		ProductCode: productCode,
+		Side:        SignalBuy,
+		Price:       price,
+		Size:        size,
+	}, save)
+}
+
+// 売却の記録を追加する処理を定義(saveがtrueの場合はDBにも保存する)
+func (s *SignalEvents) Sell(productCode string, timeTime time.Time, price, size float64, save bool) bool {
+	if !s.CanSell(timeTime) {
+		return false
+	}
+	return s.addSignal(SignalEvent{
+		Time:        timeTime,
+		ProductCode: productCode,
+		Side:        SignalSell,
+		Price:       price,
+		Size:        size,
+	}, save)
+}
+
+func (s *SignalEvents) addSignal(signalEvent SignalEvent, save bool) bool {
+	if save {
+		if err := signalEvent.Save(); err != nil {
+			log.Printf("action=addSignal err=%s", err.Error())
+			return false
+		}
+	}
+	s.Signals = append(s.Signals, signalEvent)
+	return true
+}
+
+// 記録されている売買の損益を計算する処理を定義(未決済のBUYは損益に含めない)
+func (s *SignalEvents) Profit() float64 {
+	total := 0.0
+	for _, signalEvent := range s.Signals {
+		switch signalEvent.Side {
+		case SignalBuy:
+			total -= signalEvent.Price * signalEvent.Size
+		case SignalSell:
+			total += signalEvent.Price * signalEvent.Size
+		}
+	}
+
+	// 最後の記録がBUYの場合は決済されていないため、その購入金額を戻す
+	lenSignals := len(s.Signals)
+	if lenSignals > 0 && s.Signals[lenSignals-1].Side == SignalBuy {
+		lastSignal := s.Signals[lenSignals-1]
+		total += lastSignal.Price * lastSignal.Size
+	}
+	return total
+}
+
+// 記録されている売買がBUYとSELLの交互になっているかを検証する処理を定義
+func (s *SignalEvents) Validate() error {
+	for i, signalEvent := range s.Signals {
+		if signalEvent.Side != SignalBuy && signalEvent.Side != SignalSell {
+			return fmt.Errorf("invalid side: index=%d side=%s", i, signalEvent.Side)
+		}
+		if i == 0 {
+			continue
+		}
+		before := s.Signals[i-1]
+		if before.Side == signalEvent.Side {
+			return fmt.Errorf("side is not alternating: index=%d side=%s", i, signalEvent.Side)
+		}
+		if !before.Time.Before(signalEvent.Time) {
+			return fmt.Errorf("time is not ascending: index=%d time=%s", i, signalEvent.Time.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// BUYとSELLは交互にのみ記録でき、前回の記録以前の時刻では記録できない
+func TestSignalEventsAlternation(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	type trade struct {
+		side   string
+		minute int
+		want   bool
+	}
+	tests := []struct {
+		name   string
+		trades []trade
+	}{
+		{"buy then sell", []trade{{SignalBuy, 0, true}, {SignalSell, 1, true}, {SignalBuy, 2, true}}},
+		{"sell first", []trade{{SignalSell, 0, false}, {SignalBuy, 1, true}}},
+		{"buy twice", []trade{{SignalBuy, 0, true}, {SignalBuy, 1, false}, {SignalSell, 2, true}}},
+		{"sell twice", []trade{{SignalBuy, 0, true}, {SignalSell, 1, true}, {SignalSell, 2, false}}},
+		{"sell at the same time", []trade{{SignalBuy, 0, true}, {SignalSell, 0, false}, {SignalSell, 1, true}}},
+		{"buy before the last sell", []trade{{SignalBuy, 5, true}, {SignalSell, 6, true}, {SignalBuy, 4, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := NewSignalEvents()
+			accepted := 0
+			for i, trade := range tt.trades {
+				at := base.Add(time.Duration(trade.minute) * time.Minute)
+				var got bool
+				if trade.side == SignalBuy {
+					got = events.Buy("BTC_JPY", at, 100, 1, false)
+				} else {
+					got = events.Sell("BTC_JPY", at, 100, 1, false)
+				}
+				if got != trade.want {
+					t.Errorf("trade %d %s at %d = %v, want %v", i, trade.side, trade.minute, got, trade.want)
+				}
+				if got {
+					accepted++
+				}
+			}
+			if len(events.Signals) != accepted {
+				t.Errorf("signals = %d, want %d", len(events.Signals), accepted)
+			}
+			if err := events.Validate(); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestSignalEventsProfit(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(minute int, side string, price, size float64) SignalEvent {
+		return SignalEvent{Time: base.Add(time.Duration(minute) * time.Minute), ProductCode: "BTC_JPY", Side: side, Price: price, Size: size}
+	}
+	tests := []struct {
+		name    string
+		signals []SignalEvent
+		want    float64
+	}{
+		{"no signals", nil, 0},
+		{"open buy only", []SignalEvent{event(0, SignalBuy, 100, 1)}, 0},
+		{"one winning trade", []SignalEvent{event(0, SignalBuy, 100, 2), event(1, SignalSell, 110, 2)}, 20},
+		{"one losing trade", []SignalEvent{event(0, SignalBuy, 100, 1), event(1, SignalSell, 90, 1)}, -10},
+		{"open buy is not counted", []SignalEvent{
+			event(0, SignalBuy, 100, 1), event(1, SignalSell, 120, 1), event(2, SignalBuy, 130, 1)}, 20},
+		{"several trades", []SignalEvent{
+			event(0, SignalBuy, 100, 1), event(1, SignalSell, 105, 1),
+			event(2, SignalBuy, 110, 0.5), event(3, SignalSell, 100, 0.5)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := &SignalEvents{Signals: tt.signals}
+			if got := events.Profit(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Profit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignalEventsValidate(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(minute int, side string) SignalEvent {
+		return SignalEvent{Time: base.Add(time.Duration(minute) * time.Minute), Side: side}
+	}
+	tests := []struct {
+		name    string
+		signals []SignalEvent
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"alternating", []SignalEvent{event(0, SignalBuy), event(1, SignalSell), event(2, SignalBuy)}, false},
+		{"starts with sell", []SignalEvent{event(0, SignalSell), event(1, SignalBuy)}, false},
+		{"invalid side", []SignalEvent{event(0, SignalHold)}, true},
+		{"not alternating", []SignalEvent{event(0, SignalBuy), event(1, SignalBuy)}, true},
+		{"same time", []SignalEvent{event(0, SignalBuy), event(0, SignalSell)}, true},
+		{"not ascending", []SignalEvent{event(1, SignalBuy), event(0, SignalSell)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&SignalEvents{Signals: tt.signals}).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 保存した売買の記録を銘柄ごとに読み込み、最後の記録から売買できる状態を復元する
+func TestSignalEventsSaveAndLoad(t *testing.T) {
+	resetSignalEvents(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	btc := NewSignalEvents()
+	if !btc.Buy("BTC_JPY", base, 100, 1, true) || !btc.Sell("BTC_JPY", base.Add(time.Minute), 110, 1, true) ||
+		!btc.Buy("BTC_JPY", base.Add(2*time.Minute), 120, 1, true) {
+		t.Fatalf("saving BTC_JPY signals failed")
+	}
+	// 別の銘柄は同じ時刻でも記録できる
+	eth := NewSignalEvents()
+	if !eth.Buy("ETH_JPY", base, 10, 1, true) {
+		t.Fatalf("saving ETH_JPY signal at the same time failed")
+	}
+
+	last, err := GetSignalEventsByProductCode("BTC_JPY", 1)
+	if err != nil {
+		t.Fatalf("GetSignalEventsByProductCode: %v", err)
+	}
+	if len(last.Signals) != 1 || last.Signals[0].Side != SignalBuy || last.Signals[0].Price != 120 {
+		t.Fatalf("last signals = %+v, want the BUY at 120", last.Signals)
+	}
+	if last.CanBuy(base.Add(3*time.Minute)) || !last.CanSell(base.Add(3*time.Minute)) {
+		t.Errorf("restored state: can buy = %v can sell = %v, want false true",
+			last.CanBuy(base.Add(3*time.Minute)), last.CanSell(base.Add(3*time.Minute)))
+	}
+
+	after, err := GetSignalEventsAfterTime("BTC_JPY", base.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetSignalEventsAfterTime: %v", err)
+	}
+	if len(after.Signals) != 2 || after.Signals[0].Side != SignalSell || !after.Signals[0].Time.Equal(base.Add(time.Minute)) {
+		t.Errorf("signals after time = %+v, want SELL and BUY", after.Signals)
+	}
+	if got := after.Profit(); got != 110 {
+		t.Errorf("profit of the loaded signals = %v, want 110 (open BUY is not counted)", got)
+	}
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 売買判定の結果を定義(BUY/SELLはbitFlyerのsideと同じ文字列)
+const (
+	SignalBuy  = "BUY"
+	SignalSell = "SELL"
+	SignalHold = "HOLD"
+)
+
+// 売買戦略のインターフェースを定義
+// Signalsは各Candleに対する判定結果(BUY/SELL/HOLD)をdf.Candlesと同じ長さのスライスで返す
+// i番目の判定にはi番目までのCandleの情報のみを使用すること(未来の情報を参照しない)
+type Strategy interface {
+	Name() string
+	Signals(df *DataFrameCandle) []string
+}
+
+// 戦略名と、デフォルトのパラメータで戦略を生成する関数の対応表
+var strategies = map[string]func() Strategy{
+	"breakout": func() Strategy { return &BreakoutStrategy{Period: 20} },
+	"ema":      func() Strategy { return &EmaStrategy{ShortPeriod: 7, LongPeriod: 14} },
+	"bbands":   func() Strategy { return &BBandsStrategy{N: 20, K: 2} },
+	"ichimoku": func() Strategy { return &IchimokuStrategy{Conversion: 9, Base: 26, SpanB: 52} },
+	"rsi":      func() Strategy { return &RsiStrategy{Period: 14, BuyThreshold: 30, SellThreshold: 70} },
+	"macd":     func() Strategy { return &MacdStrategy{FastPeriod: 12, SlowPeriod: 26, SignalPeriod: 9} },
+}
+
+// 戦略名から戦略を生成する処理を定義
+func NewStrategy(name string) (Strategy, error) {
+	newStrategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy: %s", name)
+	}
+	return newStrategy(), nil
+}
+
+// 登録されている戦略名の一覧を返す
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 判定結果をHOLDで初期化したスライスを生成
+func newHoldSignals(n int) []string {
+	signals := make([]string, n)
+	for i := range signals {
+		signals[i] = SignalHold
+	}
+	return signals
+}
+
+// 直近Period本の高値を上抜けたらBUY、安値を下抜けたらSELLと判定するブレイクアウト戦略
+type BreakoutStrategy struct {
+	Period int `json:"period"`
+}
+
+func (s *BreakoutStrategy) Name() string {
+	return "breakout"
+}
+
+func (s *BreakoutStrategy) Signals(df *DataFrameCandle) []string {
+	closes := df.Closes()
+	highs := df.Highs()
+	lows := df.Lows()
+	signals := newHoldSignals(len(closes))
+	if s.Period <= 0 {
+		return signals
+	}
+
+	for i := s.Period; i < len(closes); i++ {
+		// 判定対象のCandleを含まない直前Period本の高値・安値を取得
+		high, low := highs[i-s.Period], lows[i-s.Period]
+		for j := i - s.Period + 1; j < i; j++ {
+			if highs[j] > high {
+				high = highs[j]
+			}
+			if lows[j] < low {
+				low = lows[j]
+			}
+		}
+
+		if closes[i] > high {
+			signals[i] = SignalBuy
+		} else if closes[i] < low {
+			signals[i] = SignalSell
+		}
+	}
+	return signals
+}
+
+// 短期EMAが長期EMAを上抜けたら(ゴールデンクロス)BUY、下抜けたら(デッドクロス)SELLと判定する戦略
+type EmaStrategy struct {
+	ShortPeriod int `json:"short_period"`
+	LongPeriod  int `json:"long_period"`
+}
+
+func (s *EmaStrategy) Name() string {
+	return "ema"
+}
+
+func (s *EmaStrategy) Signals(df *DataFrameCandle) []string {
+	signals := newHoldSignals(len(df.Candles))
+	if s.ShortPeriod <= 0 || s.ShortPeriod >= s.LongPeriod {
+		return signals
+	}
+
+	emaShort := df.Ema(s.ShortPeriod)
+	emaLong := df.Ema(s.LongPeriod)
+	for i := s.LongPeriod; i < len(signals); i++ {
+		if emaShort[i-1] < emaLong[i-1] && emaShort[i] >= emaLong[i] {
+			signals[i] = SignalBuy
+		} else if emaShort[i-1] > emaLong[i-1] && emaShort[i] <= emaLong[i] {
+			signals[i] = SignalSell
+		}
+	}
+	return signals
+}
+
+// 終値が下のバンドを下から上に抜けたらBUY、上のバンドを上から下に抜けたらSELLと判定する戦略
+type BBandsStrategy struct {
+	N int     `json:"n"`
+	K float64 `json:"k"`
+}
+
+func (s *BBandsStrategy) Name() string {
+	return "bbands"
+}
+
+func (s *BBandsStrategy) Signals(df *DataFrameCandle) []string {
+	signals := newHoldSignals(len(df.Candles))
+	if s.N <= 0 {
+		return signals
+	}
+
+	closes := df.Closes()
+	up, _, down := df.BBands(s.N, s.K)
+	for i := s.N; i < len(signals); i++ {
+		if closes[i-1] < down[i-1] && closes[i] >= down[i] {
+			signals[i] = SignalBuy
+		} else if closes[i-1] > up[i-1] && closes[i] <= up[i] {
+			signals[i] = SignalSell
+		}
+	}
+	return signals
+}
+
+// 転換線が基準線を上抜け、且つ終値が雲(先行スパン)の上にある場合にBUY
+// 転換線が基準線を下抜け、且つ終値が雲の下にある場合にSELLと判定する戦略
+type IchimokuStrategy struct {
+	Conversion int `json:"conversion"`
+	Base       int `json:"base"`
+	SpanB      int `json:"span_b"`
+}
+
+func (s *IchimokuStrategy) Name() string {
+	return "ichimoku"
+}
+
+func (s *IchimokuStrategy) Signals(df *DataFrameCandle) []string {
+	signals := newHoldSignals(len(df.Candles))
+	if s.Conversion <= 0 || s.Base <= 0 || s.SpanB <= 0 {
+		return signals
+	}
+
+	closes := df.Closes()
+	tenkan, kijun, senkouA, senkouB, _ := df.IchimokuCloud(s.Conversion, s.Base, s.SpanB)
+	for i := s.Base + s.SpanB; i < len(signals); i++ {
+		cloudTop := math.Max(senkouA[i], senkouB[i])
+		cloudBottom := math.Min(senkouA[i], senkouB[i])
+		if tenkan[i-1] < kijun[i-1] && tenkan[i] >= kijun[i] && closes[i] > cloudTop {
+			signals[i] = SignalBuy
+		} else if tenkan[i-1] > kijun[i-1] && tenkan[i] <= kijun[i] && closes[i] < cloudBottom {
+			signals[i] = SignalSell
+		}
+	}
+	return signals
+}
+
+// RSIがBuyThresholdを下から上に抜けたらBUY、SellThresholdを上から下に抜けたらSELLと判定する戦略
+type RsiStrategy struct {
+	Period        int     `json:"period"`
+	BuyThreshold  float64 `json:"buy_threshold"`
+	SellThreshold float64 `json:"sell_threshold"`
+}
+
+func (s *RsiStrategy) Name() string {
+	return "rsi"
+}
+
+func (s *RsiStrategy) Signals(df *DataFrameCandle) []string {
+	signals := newHoldSignals(len(df.Candles))
+	if s.Period <= 0 || s.BuyThreshold >= s.SellThreshold {
+		return signals
+	}
+
+	rsi := df.Rsi(s.Period)
+	for i := s.Period + 1; i < len(signals); i++ {
+		if rsi[i-1] < s.BuyThreshold && rsi[i] >= s.BuyThreshold {
+			signals[i] = SignalBuy
+		} else if rsi[i-1] > s.SellThreshold && rsi[i] <= s.SellThreshold {
+			signals[i] = SignalSell
+		}
+	}
+	return signals
+}
+
+// MACD線とシグナル線がともに0より下でMACD線がシグナル線を上抜けたらBUY
+// ともに0より上でMACD線がシグナル線を下抜けたらSELLと判定する戦略
+type MacdStrategy struct {
+	FastPeriod   int `json:"fast_period"`
+	SlowPeriod   int `json:"slow_period"`
+	SignalPeriod int `json:"signal_period"`
+}
+
+func (s *MacdStrategy) Name() string {
+	return "macd"
+}
+
+func (s *MacdStrategy) Signals(df *DataFrameCandle) []string {
+	signals := newHoldSignals(len(df.Candles))
+	if s.FastPeriod <= 0 || s.FastPeriod >= s.SlowPeriod || s.SignalPeriod <= 0 {
+		return signals
+	}
+
+	macd, macdSignal, _ := df.Macd(s.FastPeriod, s.SlowPeriod, s.SignalPeriod)
+	for i := s.SlowPeriod + s.SignalPeriod - 1; i < len(signals); i++ {
+		if macd[i] < 0 && macdSignal[i] < 0 && macd[i-1] < macdSignal[i-1] && macd[i] >= macdSignal[i] {
+			signals[i] = SignalBuy
+		} else if macd[i] > 0 && macdSignal[i] > 0 && macd[i-1] > macdSignal[i-1] && macd[i] <= macdSignal[i] {
+			signals[i] = SignalSell
+		}
+	}
+	return signals
+}
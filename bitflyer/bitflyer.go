@@ -2,6 +2,7 @@ package bitflyer
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -13,22 +14,83 @@ import (
 	"net/url"
 	"strconv"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 // https://lightning.bitflyer.com/docs?lang=ja
-const baseURL = "https://api.bitflyer.com/v1/"
+const (
+	DefaultBaseURL      = "https://api.bitflyer.com/v1/"
+	DefaultWebSocketURL = "wss://ws.lightstream.bitflyer.com/json-rpc"
+)
 
 type APIClient struct {
-	key        string
-	secret     string
-	httpClient *http.Client
+	key          string
+	secret       string
+	httpClient   *http.Client
+	baseURL      string
+	webSocketURL string
+
+	// Realtime APIの再接続と接続状態の設定
+	reconnectMin  time.Duration
+	reconnectMax  time.Duration
+	pingInterval  time.Duration
+	readTimeout   time.Duration
+	onStateChange func(state ConnectionState, err error)
+
+	// 注文が失敗した場合の再送の設定
+	retryPolicy RetryPolicy
+
+	// API呼出回数の上限(レートリミッター)
+	publicLimiter  *rateLimiter
+	privateLimiter *rateLimiter
+	orderLimiter   *rateLimiter
+}
+
+// APIClientの設定を変更するオプションの型を定義
+type Option func(*APIClient)
+
+// REST APIのベースURLを変更するオプション(ex: モックサーバーを使用する場合)
+func WithBaseURL(baseURL string) Option {
+	return func(api *APIClient) {
+		if baseURL != "" {
+			api.baseURL = baseURL
+		}
+	}
+}
+
+// HTTP Requestのタイムアウトを変更するオプション(contextの期限の方が短い場合はそちらが優先される)
+func WithHTTPTimeout(timeout time.Duration) Option {
+	return func(api *APIClient) {
+		api.httpClient.Timeout = timeout
+	}
+}
+
+// Realtime API(JSON-RPC)の接続先URLを変更するオプション
+func WithWebSocketURL(webSocketURL string) Option {
+	return func(api *APIClient) {
+		if webSocketURL != "" {
+			api.webSocketURL = webSocketURL
+		}
+	}
 }
 
 // configで定義したapi-key, api-secret を参照するコンストラクタ
-func New(key, secret string) *APIClient {
-	apiClient := &APIClient{key, secret, &http.Client{}}
+func New(key, secret string, opts ...Option) *APIClient {
+	apiClient := &APIClient{
+		key:          key,
+		secret:       secret,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		baseURL:      DefaultBaseURL,
+		webSocketURL: DefaultWebSocketURL,
+		retryPolicy:  DefaultRetryPolicy,
+		reconnectMin: time.Second,
+		reconnectMax: time.Minute,
+		pingInterval: 15 * time.Second,
+		readTimeout:  time.Minute,
+	}
+	WithRateLimits(DefaultRateLimits)(apiClient)
+	for _, opt := range opts {
+		opt(apiClient)
+	}
 	return apiClient
 }
 
@@ -49,11 +111,11 @@ func (api APIClient) header(method, endpoint string, body []byte) map[string]str
 	}
 }
 
-// HTTP Request の定義
-func (api *APIClient) doRequest(method, urlPath string, query map[string]string, data []byte) (body []byte, err error) {
+// HTTP Request の定義(ctxがキャンセルされた場合や期限を過ぎた場合はリクエストを中断する)
+func (api *APIClient) doRequest(ctx context.Context, method, urlPath string, query map[string]string, data []byte) (body []byte, err error) {
 
 	// エンドポイントの正当性を判定 https://api.bitflyer.com/v1/
-	baseURL, err := url.Parse(baseURL)
+	baseURL, err := url.Parse(api.baseURL)
 	if err != nil {
 		return
 	}
@@ -70,8 +132,13 @@ func (api *APIClient) doRequest(method, urlPath string, query map[string]string,
 	// ログ出力処理を定義
 	log.Printf("action=doRequest endpoint=%s", endpoint)
 
+	// 呼出回数の上限を超えないように、必要な場合はリクエストの送信を待つ(署名の時刻が古くならないようにリクエストの生成前に待つ)
+	if err := waitAll(ctx, api.rateLimiters(urlPath)); err != nil {
+		return nil, err
+	}
+
 	// http requestの実行処理を定義
-	req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewBuffer(data))
 	if err != nil {
 		return
 	}
@@ -95,6 +162,7 @@ func (api *APIClient) doRequest(method, urlPath string, query map[string]string,
 	if err != nil {
 		return nil, err
 	}
+	api.observeRateLimit(urlPath, resp)
 
 	// 処理終了後に必ず実行
 	defer resp.Body.Close()
@@ -105,6 +173,11 @@ func (api *APIClient) doRequest(method, urlPath string, query map[string]string,
 		return nil, err
 	}
 
+	// bitFlyerがエラーを返した場合はAPIErrorを返却する
+	if apiErr := newAPIError(urlPath, resp.StatusCode, body); apiErr != nil {
+		return body, apiErr
+	}
+
 	// 何も存在しなければbodyの情報とnilを返却する
 	return body, nil
 }
@@ -135,10 +208,14 @@ type Balance struct {
 	Available   float64 `json:"available"` // いくら使用するか
 }
 
-//  /v1/me/getbalance にリクエストする処理を定義
+// /v1/me/getbalance にリクエストする処理を定義
 func (api *APIClient) GetBalance() ([]Balance, error) {
+	return api.GetBalanceContext(context.Background())
+}
+
+func (api *APIClient) GetBalanceContext(ctx context.Context) ([]Balance, error) {
 	url := "me/getbalance"
-	resp, err := api.doRequest("GET", url, map[string]string{}, nil)
+	resp, err := api.doRequest(ctx, "GET", url, map[string]string{}, nil)
 	log.Printf("url=%s resp=%s", url, string(resp))
 	if err != nil {
 		log.Printf("action=GetBalance err=%s", err.Error())
@@ -211,8 +288,12 @@ func (t *Ticker) TruncateDateTime(duration time.Duration) time.Time {
 
 // /v1/ticker にリクエストする処理を定義
 func (api *APIClient) GetTicker(productCode string) (*Ticker, error) {
+	return api.GetTickerContext(context.Background(), productCode)
+}
+
+func (api *APIClient) GetTickerContext(ctx context.Context, productCode string) (*Ticker, error) {
 	url := "ticker"
-	resp, err := api.doRequest("GET", url, map[string]string{"product_code": productCode}, nil)
+	resp, err := api.doRequest(ctx, "GET", url, map[string]string{"product_code": productCode}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -239,53 +320,27 @@ type SubscribeParams struct {
 }
 
 // リアルタイム通信を行うAPIを定義
+// 接続が切れた場合は再接続を繰り返すため、この関数は終了しない(goroutineで実行すること)
 func (api *APIClient) GetRealTimeTicker(symbol string, ch chan<- Ticker) {
-	u := url.URL{Scheme: "wss", Host: "ws.lightstream.bitflyer.com", Path: "/json-rpc"}
-	log.Printf("connecting to %s", u.String())
+	api.GetRealTimeTickerContext(context.Background(), symbol, ch)
+}
 
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		log.Fatal("dial:", err)
-	}
-	defer c.Close()
+// ctxがキャンセルされるまで再接続を繰り返し、キャンセルされた場合は接続を閉じてctx.Err()を返す
+func (api *APIClient) GetRealTimeTickerContext(ctx context.Context, symbol string, ch chan<- Ticker) error {
+	stream := api.NewStream()
+	stream.SubscribeTicker(symbol, ch)
+	return stream.Run(ctx)
+}
 
+// StreamでTickerを購読し、受信したTickerをchに送信する
+func (s *Stream) SubscribeTicker(symbol string, ch chan<- Ticker) *Subscription {
 	channel := fmt.Sprintf("lightning_ticker_%s", symbol)
-	if err := c.WriteJSON(&JsonRPC2{Version: "2.0", Method: "subscribe", Params: &SubscribeParams{channel}}); err != nil {
-		log.Fatal("subscribe:", err)
-		return
-	}
-
-OUTER:
-	for {
-		message := new(JsonRPC2)
-		if err := c.ReadJSON(message); err != nil {
-			log.Println("read:", err)
-			return
-		}
-
-		if message.Method == "channelMessage" {
-			switch v := message.Params.(type) {
-			case map[string]interface{}:
-				for key, binary := range v {
-					if key == "message" {
-						marshaTic, err := json.Marshal(binary)
-						if err != nil {
-							continue OUTER
-						}
-						var ticker Ticker
-						if err := json.Unmarshal(marshaTic, &ticker); err != nil {
-							continue OUTER
-						}
-						ch <- ticker
-					}
-				}
-			}
-		}
-	}
+	return s.Subscribe([]string{channel}, nil, sendTo("SubscribeTicker", ch))
 }
 
 type Order struct {
 	ID                     int     `json:"id"`
+	ChildOrderID           string  `json:"child_order_id,omitempty"`
 	ChildOrderAcceptanceID string  `json:"child_order_acceptance_id"`
 	ProductCode            string  `json:"product_code"`
 	ChildOrderType         string  `json:"child_order_type"`
@@ -316,13 +371,67 @@ type ResponseSendChildOrder struct {
 
 // リクエスト(注文)の処理を定義
 func (api *APIClient) SendOrder(order *Order) (*ResponseSendChildOrder, error) {
+	return api.SendOrderContext(context.Background(), order)
+}
+
+// ネットワークエラーや5xxで失敗した場合はRetryPolicyに従って再送する
+// 再送する前と最後の送信が失敗した後にListOrderで注文が受け付けられていないかを確認し、受け付けられていた場合はその注文を返す(二重注文の防止)
+func (api *APIClient) SendOrderContext(ctx context.Context, order *Order) (*ResponseSendChildOrder, error) {
 	data, err := json.Marshal(order)
 	if err != nil {
 		return nil, err
 	}
 
+	sentAt := time.Now()
+	checked := 0 // 受け付けられた注文を探した時に取得した最も新しい注文のid(次の確認ではこれより新しい注文のみ取得する)
+	backoff := api.retryPolicy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		response, err := api.sendChildOrder(ctx, data)
+		if err == nil {
+			return response, nil
+		}
+		if !isRetryable(err) {
+			return nil, err
+		}
+		last := attempt >= api.retryPolicy.MaxAttempts
+		if last {
+			log.Printf("action=SendOrder status=verify attempt=%d wait=%s err=%s", attempt, backoff, err.Error())
+		} else {
+			log.Printf("action=SendOrder status=retry attempt=%d wait=%s err=%s", attempt, backoff, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		// 注文が受け付けられていたかを確認できない場合は、二重注文を避けるために再送しない
+		sentOrder, newest, findErr := api.findSentOrder(ctx, order, sentAt, checked)
+		if findErr != nil {
+			log.Printf("action=SendOrder status=give_up err=%s", findErr.Error())
+			return nil, err
+		}
+		if sentOrder != nil {
+			log.Printf("action=SendOrder status=already_accepted id=%s", sentOrder.ChildOrderAcceptanceID)
+			return &ResponseSendChildOrder{ChildOrderAcceptanceID: sentOrder.ChildOrderAcceptanceID}, nil
+		}
+		if last {
+			return nil, err
+		}
+		checked = newest
+
+		backoff *= 2
+		if backoff > api.retryPolicy.MaxBackoff {
+			backoff = api.retryPolicy.MaxBackoff
+		}
+	}
+}
+
+// /v1/me/sendchildorder に1回だけリクエストする処理を定義
+func (api *APIClient) sendChildOrder(ctx context.Context, data []byte) (*ResponseSendChildOrder, error) {
 	url := "me/sendchildorder"
-	resp, err := api.doRequest("POST", url, map[string]string{}, data)
+	resp, err := api.doRequest(ctx, "POST", url, map[string]string{}, data)
 	if err != nil {
 		return nil, err
 	}
@@ -338,7 +447,11 @@ func (api *APIClient) SendOrder(order *Order) (*ResponseSendChildOrder, error) {
 
 // リクエスト(参照)の処理を定義
 func (api *APIClient) ListOrder(query map[string]string) ([]Order, error) {
-	resp, err := api.doRequest("GET", "me/getchildorders", query, nil)
+	return api.ListOrderContext(context.Background(), query)
+}
+
+func (api *APIClient) ListOrderContext(ctx context.Context, query map[string]string) ([]Order, error) {
+	resp, err := api.doRequest(ctx, "GET", "me/getchildorders", query, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -349,3 +462,47 @@ func (api *APIClient) ListOrder(query map[string]string) ([]Order, error) {
 	}
 	return responseListOrder, nil
 }
+
+// POST /v1/me/cancelchildorder -Request Body Sample-
+// {
+//   "product_code": "BTC_JPY",
+//   "child_order_id": "JOR20150707-055555-022222"
+// }
+
+// 注文をキャンセルする時のパラメータを定義(child_order_idかchild_order_acceptance_idのどちらかを指定)
+type CancelChildOrder struct {
+	ProductCode            string `json:"product_code"`
+	ChildOrderID           string `json:"child_order_id,omitempty"`
+	ChildOrderAcceptanceID string `json:"child_order_acceptance_id,omitempty"`
+}
+
+// 注文をキャンセルする処理を定義(成功した場合はレスポンスのbodyは空)
+func (api *APIClient) CancelChildOrder(cancel *CancelChildOrder) error {
+	return api.CancelChildOrderContext(context.Background(), cancel)
+}
+
+func (api *APIClient) CancelChildOrderContext(ctx context.Context, cancel *CancelChildOrder) error {
+	if cancel.ChildOrderID == "" && cancel.ChildOrderAcceptanceID == "" {
+		return fmt.Errorf("child_order_id or child_order_acceptance_id is required")
+	}
+	data, err := json.Marshal(cancel)
+	if err != nil {
+		return err
+	}
+	_, err = api.doRequest(ctx, "POST", "me/cancelchildorder", map[string]string{}, data)
+	return err
+}
+
+// 指定したproduct_codeの全ての注文をキャンセルする処理を定義
+func (api *APIClient) CancelAllChildOrders(productCode string) error {
+	return api.CancelAllChildOrdersContext(context.Background(), productCode)
+}
+
+func (api *APIClient) CancelAllChildOrdersContext(ctx context.Context, productCode string) error {
+	data, err := json.Marshal(map[string]string{"product_code": productCode})
+	if err != nil {
+		return err
+	}
+	_, err = api.doRequest(ctx, "POST", "me/cancelallchildorders", map[string]string{}, data)
+	return err
+}
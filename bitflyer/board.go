@@ -0,0 +1,343 @@
+package bitflyer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GET /v1/board -API Response Sample-
+// {
+//   "mid_price": 33320,
+//   "bids": [
+//     {
+//       "price": 30000,
+//       "size": 0.1
+//     },
+//     {
+//       "price": 25570,
+//       "size": 3
+//     }
+//   ],
+//   "asks": [
+//     {
+//       "price": 36640,
+//       "size": 5
+//     },
+//     {
+//       "price": 36700,
+//       "size": 1.2
+//     }
+//   ]
+// }
+
+// 板の1つの価格の注文数量の型を定義
+type BoardOrder struct {
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+// 板情報の型を定義(Realtime APIの差分では、sizeが0の価格は注文がなくなったことを表す)
+type Board struct {
+	MidPrice float64      `json:"mid_price"`
+	Bids     []BoardOrder `json:"bids"`
+	Asks     []BoardOrder `json:"asks"`
+}
+
+// /v1/board にリクエストする処理を定義
+func (api *APIClient) GetBoard(productCode string) (*Board, error) {
+	return api.GetBoardContext(context.Background(), productCode)
+}
+
+func (api *APIClient) GetBoardContext(ctx context.Context, productCode string) (*Board, error) {
+	resp, err := api.doRequest(ctx, "GET", "board", map[string]string{"product_code": productCode}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var board Board
+	if err := json.Unmarshal(resp, &board); err != nil {
+		return nil, err
+	}
+	return &board, nil
+}
+
+// スナップショットと差分から最新の板を保持する構造体を定義(複数のgoroutineから参照できる)
+type OrderBook struct {
+	ProductCode string
+
+	mu        sync.RWMutex
+	midPrice  float64
+	bids      map[float64]float64 // 価格 => 数量
+	asks      map[float64]float64
+	ready     bool // スナップショットを受信済みかどうか
+	updatedAt time.Time
+}
+
+// 板を保持するOrderBookを生成するコンストラクタ
+func NewOrderBook(productCode string) *OrderBook {
+	return &OrderBook{
+		ProductCode: productCode,
+		bids:        map[float64]float64{},
+		asks:        map[float64]float64{},
+	}
+}
+
+// スナップショットで板全体を置き換える
+func (b *OrderBook) ApplySnapshot(board *Board) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bids = map[float64]float64{}
+	b.asks = map[float64]float64{}
+	b.apply(board)
+	b.ready = true
+}
+
+// 差分を板に反映する(スナップショットを受信する前の差分は反映できないため無視する)
+func (b *OrderBook) ApplyDiff(board *Board) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ready {
+		return
+	}
+	b.apply(board)
+}
+
+func (b *OrderBook) apply(board *Board) {
+	if board.MidPrice > 0 {
+		b.midPrice = board.MidPrice
+	}
+	applyLevels(b.bids, board.Bids)
+	applyLevels(b.asks, board.Asks)
+
+	// 約定の反映が遅れて最良気配が交差した場合は、交差した価格の注文を取り除く
+	bestBid, bestAsk := b.bestBid(), b.bestAsk()
+	for bestBid > 0 && bestAsk > 0 && bestBid >= bestAsk {
+		if board.MidPrice > 0 && bestBid > board.MidPrice {
+			delete(b.bids, bestBid)
+		} else {
+			delete(b.asks, bestAsk)
+		}
+		bestBid, bestAsk = b.bestBid(), b.bestAsk()
+	}
+	b.updatedAt = time.Now()
+}
+
+func applyLevels(levels map[float64]float64, orders []BoardOrder) {
+	for _, order := range orders {
+		if order.Size <= 0 {
+			delete(levels, order.Price)
+		} else {
+			levels[order.Price] = order.Size
+		}
+	}
+}
+
+// 次のスナップショットを受信するまで差分を反映しないようにする(再接続時に使用)
+func (b *OrderBook) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ready = false
+}
+
+// スナップショットを受信済みで板を参照できる状態かどうか
+func (b *OrderBook) Ready() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ready
+}
+
+// 板を最後に更新した時刻
+func (b *OrderBook) UpdatedAt() time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.updatedAt
+}
+
+// 仲値(受信した板のmid_price)
+func (b *OrderBook) MidPrice() float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.midPrice
+}
+
+// 最良買気配(注文がない場合は0)
+func (b *OrderBook) BestBid() BoardOrder {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	price := b.bestBid()
+	return BoardOrder{Price: price, Size: b.bids[price]}
+}
+
+// 最良売気配(注文がない場合は0)
+func (b *OrderBook) BestAsk() BoardOrder {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	price := b.bestAsk()
+	return BoardOrder{Price: price, Size: b.asks[price]}
+}
+
+func (b *OrderBook) bestBid() float64 {
+	var best float64
+	for price := range b.bids {
+		if price > best {
+			best = price
+		}
+	}
+	return best
+}
+
+func (b *OrderBook) bestAsk() float64 {
+	var best float64
+	for price := range b.asks {
+		if best == 0 || price < best {
+			best = price
+		}
+	}
+	return best
+}
+
+// 買い板を価格の高い順にdepth件返す(depthが0以下の場合は全件)
+func (b *OrderBook) Bids(depth int) []BoardOrder {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sortedLevels(b.bids, depth, true)
+}
+
+// 売り板を価格の安い順にdepth件返す(depthが0以下の場合は全件)
+func (b *OrderBook) Asks(depth int) []BoardOrder {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sortedLevels(b.asks, depth, false)
+}
+
+func sortedLevels(levels map[float64]float64, depth int, descending bool) []BoardOrder {
+	orders := make([]BoardOrder, 0, len(levels))
+	for price, size := range levels {
+		orders = append(orders, BoardOrder{Price: price, Size: size})
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if descending {
+			return orders[i].Price > orders[j].Price
+		}
+		return orders[i].Price < orders[j].Price
+	})
+	if depth > 0 && len(orders) > depth {
+		orders = orders[:depth]
+	}
+	return orders
+}
+
+// 現在の板をBoardとして返す(depthは片側あたりの件数)
+func (b *OrderBook) Snapshot(depth int) *Board {
+	return &Board{MidPrice: b.MidPrice(), Bids: b.Bids(depth), Asks: b.Asks(depth)}
+}
+
+// 指定した価格の注文数量を返す(sideはBUYの場合は買い板、SELLの場合は売り板)
+func (b *OrderBook) SizeAt(side string, price float64) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if side == "BUY" {
+		return b.bids[price]
+	}
+	return b.asks[price]
+}
+
+// 最良気配からpriceまでの注文数量の合計を返す(sideはBUYの場合は買い板、SELLの場合は売り板)
+func (b *OrderBook) DepthTo(side string, price float64) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var depth float64
+	if side == "BUY" {
+		for p, size := range b.bids {
+			if p >= price {
+				depth += size
+			}
+		}
+		return depth
+	}
+	for p, size := range b.asks {
+		if p <= price {
+			depth += size
+		}
+	}
+	return depth
+}
+
+// sizeを成行で注文した場合の平均約定価格を板から見積もる
+// sideがBUYの場合は売り板、SELLの場合は買い板を消費する。板が足りない場合は約定できた数量をfilledで返す
+func (b *OrderBook) EstimateExecution(side string, size float64) (averagePrice, filled float64) {
+	var levels []BoardOrder
+	if side == "BUY" {
+		levels = b.Asks(0)
+	} else {
+		levels = b.Bids(0)
+	}
+
+	var amount float64
+	for _, level := range levels {
+		if filled >= size {
+			break
+		}
+		executed := level.Size
+		if filled+executed > size {
+			executed = size - filled
+		}
+		amount += level.Price * executed
+		filled += executed
+	}
+	if filled > 0 {
+		averagePrice = amount / filled
+	}
+	return averagePrice, filled
+}
+
+// 成行で注文した場合のスリッページ(最良気配に対する平均約定価格の不利な差の割合)を見積もる
+func (b *OrderBook) EstimateSlippage(side string, size float64) (float64, error) {
+	averagePrice, filled := b.EstimateExecution(side, size)
+	if filled < size {
+		return 0, fmt.Errorf("not enough depth on the board: size=%f filled=%f", size, filled)
+	}
+	if side == "BUY" {
+		best := b.BestAsk().Price
+		return (averagePrice - best) / best, nil
+	}
+	best := b.BestBid().Price
+	return (best - averagePrice) / best, nil
+}
+
+// Realtime APIで板のスナップショットと差分を購読し、bookを最新の状態に保つ処理を定義
+// 接続が切れた場合は再接続を繰り返すため、この関数は終了しない(goroutineで実行すること)
+func (api *APIClient) GetRealTimeBoard(symbol string, book *OrderBook) {
+	api.GetRealTimeBoardContext(context.Background(), symbol, book)
+}
+
+// ctxがキャンセルされるまで再接続を繰り返し、キャンセルされた場合は接続を閉じてctx.Err()を返す
+func (api *APIClient) GetRealTimeBoardContext(ctx context.Context, symbol string, book *OrderBook) error {
+	stream := api.NewStream()
+	stream.SubscribeBoard(symbol, book)
+	return stream.Run(ctx)
+}
+
+// Streamで板のスナップショットと差分を購読し、bookに反映する
+func (s *Stream) SubscribeBoard(symbol string, book *OrderBook) *Subscription {
+	snapshotChannel := fmt.Sprintf("lightning_board_snapshot_%s", symbol)
+	diffChannel := fmt.Sprintf("lightning_board_%s", symbol)
+
+	// 再接続した場合は切断中の差分を取りこぼしているため、次のスナップショットを受信するまで差分を反映しない
+	return s.Subscribe([]string{snapshotChannel, diffChannel}, book.Reset, func(ctx context.Context, channel string, message json.RawMessage) {
+		var board Board
+		if err := json.Unmarshal(message, &board); err != nil {
+			log.Printf("action=SubscribeBoard err=%s", err.Error())
+			return
+		}
+		if channel == snapshotChannel {
+			book.ApplySnapshot(&board)
+		} else {
+			book.ApplyDiff(&board)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package bitflyer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func levels(priceSizes ...float64) []BoardOrder {
+	orders := make([]BoardOrder, 0, len(priceSizes)/2)
+	for i := 0; i+1 < len(priceSizes); i += 2 {
+		orders = append(orders, BoardOrder{Price: priceSizes[i], Size: priceSizes[i+1]})
+	}
+	return orders
+}
+
+func TestOrderBookApply(t *testing.T) {
+	snapshot := &Board{MidPrice: 100, Bids: levels(99, 1, 98, 2, 97, 3), Asks: levels(101, 1, 102, 2, 103, 3)}
+	tests := []struct {
+		name     string
+		reset    bool // 差分の前にResetする
+		diffs    []*Board
+		wantMid  float64
+		wantBids []BoardOrder
+		wantAsks []BoardOrder
+	}{
+		{"snapshot only", false, nil,
+			100, levels(99, 1, 98, 2, 97, 3), levels(101, 1, 102, 2, 103, 3)},
+		{"update and add levels", false, []*Board{{MidPrice: 100.5, Bids: levels(99, 5, 99.5, 1), Asks: levels(104, 1)}},
+			100.5, levels(99.5, 1, 99, 5, 98, 2, 97, 3), levels(101, 1, 102, 2, 103, 3, 104, 1)},
+		{"size 0 removes a level", false, []*Board{{Bids: levels(98, 0), Asks: levels(101, 0, 105, 0)}},
+			100, levels(99, 1, 97, 3), levels(102, 2, 103, 3)},
+		{"mid price 0 keeps the previous mid price", false, []*Board{{Bids: levels(97, 4)}},
+			100, levels(99, 1, 98, 2, 97, 4), levels(101, 1, 102, 2, 103, 3)},
+		{"crossed bid above mid price is removed", false, []*Board{{MidPrice: 100, Bids: levels(101.5, 1)}},
+			100, levels(99, 1, 98, 2, 97, 3), levels(101, 1, 102, 2, 103, 3)},
+		{"crossed ask is removed", false, []*Board{{MidPrice: 102, Bids: levels(101.5, 1)}},
+			102, levels(101.5, 1, 99, 1, 98, 2, 97, 3), levels(102, 2, 103, 3)},
+		{"diffs are applied in order", false, []*Board{{Asks: levels(101, 4)}, {Asks: levels(101, 0)}, {Asks: levels(101, 6)}},
+			100, levels(99, 1, 98, 2, 97, 3), levels(101, 6, 102, 2, 103, 3)},
+		{"diffs after reset are ignored", true, []*Board{{MidPrice: 50, Bids: levels(99, 0), Asks: levels(101, 0)}},
+			100, levels(99, 1, 98, 2, 97, 3), levels(101, 1, 102, 2, 103, 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := NewOrderBook("BTC_JPY")
+			book.ApplyDiff(&Board{Bids: levels(50, 1)})
+			if book.Ready() || len(book.Bids(0)) != 0 {
+				t.Fatalf("diff before the snapshot was applied")
+			}
+			book.ApplySnapshot(snapshot)
+			if tt.reset {
+				book.Reset()
+			}
+			for _, diff := range tt.diffs {
+				book.ApplyDiff(diff)
+			}
+
+			if got := book.MidPrice(); got != tt.wantMid {
+				t.Errorf("mid price = %v, want %v", got, tt.wantMid)
+			}
+			if got := book.Bids(0); !reflect.DeepEqual(got, tt.wantBids) {
+				t.Errorf("bids = %v, want %v", got, tt.wantBids)
+			}
+			if got := book.Asks(0); !reflect.DeepEqual(got, tt.wantAsks) {
+				t.Errorf("asks = %v, want %v", got, tt.wantAsks)
+			}
+		})
+	}
+}
+
+// 新しいスナップショットを受信した場合は板全体を置き換え、Reset後も差分の反映を再開する
+func TestOrderBookSnapshotReplaces(t *testing.T) {
+	book := NewOrderBook("BTC_JPY")
+	book.ApplySnapshot(&Board{MidPrice: 100, Bids: levels(99, 1, 98, 2), Asks: levels(101, 1)})
+	book.Reset()
+	book.ApplySnapshot(&Board{MidPrice: 200, Bids: levels(199, 1), Asks: levels(201, 1, 202, 1)})
+	book.ApplyDiff(&Board{Asks: levels(201, 0)})
+
+	if !book.Ready() {
+		t.Fatalf("book is not ready after the snapshot")
+	}
+	if got, want := book.Bids(0), levels(199, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("bids = %v, want %v", got, want)
+	}
+	if got, want := book.Asks(0), levels(202, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("asks = %v, want %v", got, want)
+	}
+}
+
+func TestOrderBookEstimate(t *testing.T) {
+	book := NewOrderBook("BTC_JPY")
+	book.ApplySnapshot(&Board{MidPrice: 100, Bids: levels(99, 1, 98, 2), Asks: levels(101, 1, 102, 2)})
+	tests := []struct {
+		name         string
+		side         string
+		size         float64
+		wantAverage  float64
+		wantFilled   float64
+		wantSlippage float64
+		wantErr      bool
+	}{
+		{"buy within the best ask", "BUY", 0.5, 101, 0.5, 0, false},
+		{"buy across levels", "BUY", 2, 101.5, 2, 0.5 / 101, false},
+		{"sell across levels", "SELL", 3, (99 + 98*2) / 3.0, 3, (99 - (99+98*2)/3.0) / 99, false},
+		{"not enough depth", "BUY", 5, (101 + 102*2) / 3.0, 3, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			average, filled := book.EstimateExecution(tt.side, tt.size)
+			if math.Abs(average-tt.wantAverage) > 1e-9 || filled != tt.wantFilled {
+				t.Errorf("EstimateExecution = %v, %v, want %v, %v", average, filled, tt.wantAverage, tt.wantFilled)
+			}
+			slippage, err := book.EstimateSlippage(tt.side, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EstimateSlippage err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && math.Abs(slippage-tt.wantSlippage) > 1e-9 {
+				t.Errorf("EstimateSlippage = %v, want %v", slippage, tt.wantSlippage)
+			}
+		})
+	}
+	if got := book.DepthTo("BUY", 98); got != 3 {
+		t.Errorf("DepthTo BUY 98 = %v, want 3", got)
+	}
+	if got := book.DepthTo("SELL", 101); got != 1 {
+		t.Errorf("DepthTo SELL 101 = %v, want 1", got)
+	}
+	if got := book.SizeAt("SELL", 102); got != 2 {
+		t.Errorf("SizeAt SELL 102 = %v, want 2", got)
+	}
+}
@@ -0,0 +1,111 @@
+package bitflyer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bitFlyerが返すエラーレスポンス -API Response Sample-
+// {
+//   "status": -208,
+//   "error_message": "Order is not accepted. Please try again later.",
+//   "data": null
+// }
+
+// bitFlyerのAPIがエラーを返した場合のエラー型を定義
+type APIError struct {
+	HTTPStatus int    `json:"-"`             // HTTPのステータスコード
+	Status     int    `json:"status"`        // bitFlyerのステータスコード(負の値)
+	Message    string `json:"error_message"` // bitFlyerのエラーメッセージ
+	Endpoint   string `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("bitflyer: endpoint=%s http_status=%d status=%d message=%s", e.Endpoint, e.HTTPStatus, e.Status, e.Message)
+}
+
+// bitFlyerのステータスコード
+// https://lightning.bitflyer.com/docs?lang=ja
+const (
+	StatusInvalidParams      = -100 // -100〜-199 はパラメータの誤り
+	StatusInsufficientFunds  = -200
+	StatusInsufficientMargin = -205
+	StatusOrderNotAccepted   = -208
+	StatusKeyNotFound        = -500
+)
+
+// レスポンスがエラーかどうかを判定し、エラーの場合はAPIErrorを返す
+// HTTPのステータスコードが2xxでも、bodyに負のstatusが含まれている場合はエラーとして扱う
+func newAPIError(endpoint string, httpStatus int, body []byte) *APIError {
+	apiErr := &APIError{}
+	isJSONError := json.Unmarshal(body, apiErr) == nil && apiErr.Status < 0
+	if httpStatus >= 200 && httpStatus < 300 && !isJSONError {
+		return nil
+	}
+
+	apiErr.HTTPStatus = httpStatus
+	apiErr.Endpoint = endpoint
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(httpStatus)
+		}
+	}
+	return apiErr
+}
+
+// errがAPIErrorの場合はそれを取り出す
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// 残高・証拠金不足のエラーかどうかを判定
+func IsInsufficientFunds(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.Status == StatusInsufficientFunds ||
+		apiErr.Status == StatusInsufficientMargin ||
+		strings.Contains(strings.ToLower(apiErr.Message), "insufficient")
+}
+
+// リクエスト数の上限を超えたエラーかどうかを判定
+func IsRateLimited(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.HTTPStatus == http.StatusTooManyRequests ||
+		strings.Contains(strings.ToLower(apiErr.Message), "api limit")
+}
+
+// パラメータの誤りによるエラーかどうかを判定
+func IsInvalidParams(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	if apiErr.Status <= StatusInvalidParams && apiErr.Status > StatusInsufficientFunds {
+		return true
+	}
+	return apiErr.HTTPStatus == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Message), "invalid")
+}
+
+// 認証(APIキー・署名)の失敗によるエラーかどうかを判定
+func IsAuthFailure(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.HTTPStatus == http.StatusUnauthorized ||
+		apiErr.HTTPStatus == http.StatusForbidden ||
+		apiErr.Status == StatusKeyNotFound
+}
@@ -0,0 +1,96 @@
+package bitflyer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		httpStatus int
+		body       string
+		want       *APIError
+	}{
+		{"success", http.StatusOK, `{"child_order_acceptance_id":"JRF20150707-050237-639234"}`, nil},
+		{"success with an array", http.StatusOK, `[]`, nil},
+		{"success with status 0", http.StatusOK, `{"status":0}`, nil},
+		{"error in a 2xx response", http.StatusOK, `{"status":-208,"error_message":"Order is not accepted. Please try again later.","data":null}`,
+			&APIError{HTTPStatus: http.StatusOK, Status: StatusOrderNotAccepted, Message: "Order is not accepted. Please try again later."}},
+		{"json error", http.StatusBadRequest, `{"status":-200,"error_message":"Insufficient funds","data":null}`,
+			&APIError{HTTPStatus: http.StatusBadRequest, Status: StatusInsufficientFunds, Message: "Insufficient funds"}},
+		{"plain text body", http.StatusBadGateway, " Bad Gateway from proxy \n",
+			&APIError{HTTPStatus: http.StatusBadGateway, Message: "Bad Gateway from proxy"}},
+		{"empty body", http.StatusServiceUnavailable, "",
+			&APIError{HTTPStatus: http.StatusServiceUnavailable, Message: "Service Unavailable"}},
+		{"json without a message", http.StatusInternalServerError, `{"status":-1}`,
+			&APIError{HTTPStatus: http.StatusInternalServerError, Status: -1, Message: `{"status":-1}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newAPIError("me/sendchildorder", tt.httpStatus, []byte(tt.body))
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("newAPIError = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("newAPIError = nil, want %v", tt.want)
+			}
+			tt.want.Endpoint = "me/sendchildorder"
+			if *got != *tt.want {
+				t.Errorf("newAPIError = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorClassifiers(t *testing.T) {
+	apiError := func(httpStatus, status int, message string) error {
+		return fmt.Errorf("wrapped: %w", &APIError{HTTPStatus: httpStatus, Status: status, Message: message})
+	}
+	tests := []struct {
+		name              string
+		err               error
+		insufficientFunds bool
+		rateLimited       bool
+		invalidParams     bool
+		authFailure       bool
+	}{
+		{"nil", nil, false, false, false, false},
+		{"not an api error", errors.New("insufficient funds"), false, false, false, false},
+		{"insufficient funds", apiError(400, StatusInsufficientFunds, "Insufficient funds"), true, false, false, false},
+		{"insufficient margin", apiError(400, StatusInsufficientMargin, "Margin amount is insufficient for this order"), true, false, false, false},
+		{"insufficient message only", apiError(400, -1, "Insufficient balance"), true, false, false, false},
+		{"too many requests", apiError(http.StatusTooManyRequests, 0, "Too Many Requests"), false, true, false, false},
+		{"api limit message", apiError(400, -1, "Over API limit per period, per IP address"), false, true, false, false},
+		{"invalid params status", apiError(400, StatusInvalidParams, "Invalid product"), false, false, true, false},
+		{"invalid params range", apiError(400, -199, "Invalid size"), false, false, true, false},
+		{"status -200 is not invalid params", apiError(http.StatusOK, StatusInsufficientFunds, "Invalid funds"), true, false, false, false},
+		{"invalid message with 400", apiError(http.StatusBadRequest, -1, "Invalid signature timestamp"), false, false, true, false},
+		{"invalid message with 500", apiError(http.StatusInternalServerError, -1, "Invalid state"), false, false, false, false},
+		{"unauthorized", apiError(http.StatusUnauthorized, 0, "Unauthorized"), false, false, false, true},
+		{"forbidden", apiError(http.StatusForbidden, 0, "Forbidden"), false, false, false, true},
+		{"key not found", apiError(http.StatusOK, StatusKeyNotFound, "Key not found"), false, false, false, true},
+		{"order not accepted", apiError(http.StatusOK, StatusOrderNotAccepted, "Order is not accepted"), false, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsInsufficientFunds(tt.err); got != tt.insufficientFunds {
+				t.Errorf("IsInsufficientFunds = %v, want %v", got, tt.insufficientFunds)
+			}
+			if got := IsRateLimited(tt.err); got != tt.rateLimited {
+				t.Errorf("IsRateLimited = %v, want %v", got, tt.rateLimited)
+			}
+			if got := IsInvalidParams(tt.err); got != tt.invalidParams {
+				t.Errorf("IsInvalidParams = %v, want %v", got, tt.invalidParams)
+			}
+			if got := IsAuthFailure(tt.err); got != tt.authFailure {
+				t.Errorf("IsAuthFailure = %v, want %v", got, tt.authFailure)
+			}
+		})
+	}
+}
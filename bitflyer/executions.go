@@ -0,0 +1,185 @@
+package bitflyer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// GET /v1/executions -API Response Sample-
+// [
+//   {
+//     "id": 39287,
+//     "side": "BUY",
+//     "price": 31690,
+//     "size": 27.04,
+//     "exec_date": "2015-07-08T02:43:34.823",
+//     "buy_child_order_acceptance_id": "JRF20150707-200203-452209",
+//     "sell_child_order_acceptance_id": "JRF20150708-024334-060234"
+//   }
+// ]
+
+// 約定履歴の型を定義
+type Execution struct {
+	ID                         int     `json:"id"`
+	Side                       string  `json:"side"`
+	Price                      float64 `json:"price"`
+	Size                       float64 `json:"size"`
+	ExecDate                   string  `json:"exec_date"`
+	BuyChildOrderAcceptanceID  string  `json:"buy_child_order_acceptance_id"`
+	SellChildOrderAcceptanceID string  `json:"sell_child_order_acceptance_id"`
+}
+
+// 約定日時をtime.Timeに変換するメソッド
+func (e *Execution) DateTime() time.Time {
+	return parseExecDate(e.ExecDate)
+}
+
+// exec_dateはタイムゾーンなしのUTC(ex: 2015-07-08T02:43:34.823)で返されるため、タイムゾーンがない場合はUTCとして扱う
+func parseExecDate(execDate string) time.Time {
+	if dateTime, err := time.Parse(time.RFC3339Nano, execDate); err == nil {
+		return dateTime
+	}
+	dateTime, err := time.Parse("2006-01-02T15:04:05.999999999", execDate)
+	if err != nil {
+		log.Printf("action=parseExecDate, err=%s", err.Error())
+	}
+	return dateTime
+}
+
+// 約定履歴を取得する処理を定義
+// queryには product_code, count, before, after を指定できる(idがbeforeより小さく、afterより大きいものを新しい順に返す)
+func (api *APIClient) GetExecutions(query map[string]string) ([]Execution, error) {
+	return api.GetExecutionsContext(context.Background(), query)
+}
+
+func (api *APIClient) GetExecutionsContext(ctx context.Context, query map[string]string) ([]Execution, error) {
+	resp, err := api.doRequest(ctx, "GET", "executions", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var executions []Execution
+	if err := json.Unmarshal(resp, &executions); err != nil {
+		return nil, err
+	}
+	return executions, nil
+}
+
+// 約定履歴を1回のリクエストで取得する件数(APIの上限)
+const executionsPageSize = 500
+
+// 約定履歴を新しい順にページングして取得し、1ページずつfnに渡す処理を定義
+// idがbeforeより小さく、afterより大きい約定を対象とする(beforeが0の場合は最新の約定から、afterが0の場合は取得できる最も古い約定まで)
+// fnがfalseを返すか、取得できる約定がなくなった時点で終了する
+func (api *APIClient) PageExecutions(productCode string, before, after int, fn func(executions []Execution) bool) error {
+	return api.PageExecutionsContext(context.Background(), productCode, before, after, fn)
+}
+
+func (api *APIClient) PageExecutionsContext(ctx context.Context, productCode string, before, after int, fn func(executions []Execution) bool) error {
+	for {
+		query := map[string]string{
+			"product_code": productCode,
+			"count":        strconv.Itoa(executionsPageSize),
+		}
+		if before > 0 {
+			query["before"] = strconv.Itoa(before)
+		}
+		if after > 0 {
+			query["after"] = strconv.Itoa(after)
+		}
+		executions, err := api.GetExecutionsContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		if len(executions) == 0 || !fn(executions) {
+			return nil
+		}
+		// 新しい順に返されるため、最後の約定のidを次のページのbeforeとする
+		before = executions[len(executions)-1].ID
+	}
+}
+
+// GET /v1/me/getexecutions -API Response Sample-
+// [
+//   {
+//     "id": 37233,
+//     "child_order_id": "JOR20150707-060559-021935",
+//     "side": "BUY",
+//     "price": 33470,
+//     "size": 0.01,
+//     "commission": 0,
+//     "exec_date": "2015-07-07T09:57:40.397",
+//     "child_order_acceptance_id": "JRF20150707-060559-396699"
+//   }
+// ]
+
+// 自分の注文の約定履歴の型を定義
+type MyExecution struct {
+	ID                     int     `json:"id"`
+	ChildOrderID           string  `json:"child_order_id"`
+	Side                   string  `json:"side"`
+	Price                  float64 `json:"price"`
+	Size                   float64 `json:"size"`
+	Commission             float64 `json:"commission"`
+	ExecDate               string  `json:"exec_date"`
+	ChildOrderAcceptanceID string  `json:"child_order_acceptance_id"`
+}
+
+// 約定日時をtime.Timeに変換するメソッド
+func (e *MyExecution) DateTime() time.Time {
+	return parseExecDate(e.ExecDate)
+}
+
+// 自分の注文の約定履歴を取得する処理を定義
+// queryには product_code, count, before, after, child_order_id, child_order_acceptance_id を指定できる
+func (api *APIClient) GetMyExecutions(query map[string]string) ([]MyExecution, error) {
+	return api.GetMyExecutionsContext(context.Background(), query)
+}
+
+func (api *APIClient) GetMyExecutionsContext(ctx context.Context, query map[string]string) ([]MyExecution, error) {
+	resp, err := api.doRequest(ctx, "GET", "me/getexecutions", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var executions []MyExecution
+	if err := json.Unmarshal(resp, &executions); err != nil {
+		return nil, err
+	}
+	return executions, nil
+}
+
+// 約定履歴から約定数量で加重平均した約定価格、約定数量の合計、手数料の合計を計算する
+func SummarizeExecutions(executions []MyExecution) (averagePrice, size, commission float64) {
+	var amount float64
+	for _, e := range executions {
+		amount += e.Price * e.Size
+		size += e.Size
+		commission += e.Commission
+	}
+	if size > 0 {
+		averagePrice = amount / size
+	}
+	return averagePrice, size, commission
+}
+
+// Realtime APIで約定履歴を購読する処理を定義(1回のメッセージに含まれる約定をまとめてchに送信する)
+// 接続が切れた場合は再接続を繰り返すため、この関数は終了しない(goroutineで実行すること)
+func (api *APIClient) GetRealTimeExecutions(symbol string, ch chan<- []Execution) {
+	api.GetRealTimeExecutionsContext(context.Background(), symbol, ch)
+}
+
+// ctxがキャンセルされるまで再接続を繰り返し、キャンセルされた場合は接続を閉じてctx.Err()を返す
+func (api *APIClient) GetRealTimeExecutionsContext(ctx context.Context, symbol string, ch chan<- []Execution) error {
+	stream := api.NewStream()
+	stream.SubscribeExecutions(symbol, ch)
+	return stream.Run(ctx)
+}
+
+// Streamで約定履歴を購読し、受信した約定をchに送信する
+func (s *Stream) SubscribeExecutions(symbol string, ch chan<- []Execution) *Subscription {
+	channel := fmt.Sprintf("lightning_executions_%s", symbol)
+	return s.Subscribe([]string{channel}, nil, sendTo("SubscribeExecutions", ch))
+}
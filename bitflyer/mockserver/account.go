@@ -0,0 +1,287 @@
+package mockserver
+
+import (
+	"gotrading/bitflyer"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 証拠金取引(FX_BTC_JPYなど)のレバレッジ
+const leverage = 2
+
+// 保持する約定履歴の上限
+const maxExecutions = 10000
+
+// 自分の注文の約定履歴(レスポンスに含まれない銘柄を合わせて保持する)
+type myExecution struct {
+	bitflyer.MyExecution
+	productCode string
+}
+
+// 証拠金取引の銘柄かどうかを判定する
+func isMarginProduct(productCode string) bool {
+	return strings.HasPrefix(productCode, "FX_")
+}
+
+// 約定履歴を追加する(古いものから削除して上限を保つ)
+func (s *Server) addExecution(productCode, side string, price, size float64, buyID, sellID string) bitflyer.Execution {
+	s.executionID++
+	execution := bitflyer.Execution{
+		ID:                         s.executionID,
+		Side:                       side,
+		Price:                      price,
+		Size:                       size,
+		ExecDate:                   time.Now().UTC().Format("2006-01-02T15:04:05.000"),
+		BuyChildOrderAcceptanceID:  buyID,
+		SellChildOrderAcceptanceID: sellID,
+	}
+	executions := append(s.executions[productCode], execution)
+	if len(executions) > maxExecutions {
+		executions = executions[len(executions)-maxExecutions:]
+	}
+	s.executions[productCode] = executions
+	return execution
+}
+
+// 前回の配信以降に追加された約定履歴を返す
+func (s *Server) unpublishedExecutions(productCode string) []bitflyer.Execution {
+	all := s.executions[productCode]
+	i := len(all)
+	for i > 0 && all[i-1].ID > s.publishedExecutionID[productCode] {
+		i--
+	}
+	executions := append([]bitflyer.Execution{}, all[i:]...)
+	if len(executions) > 0 {
+		s.publishedExecutionID[productCode] = executions[len(executions)-1].ID
+	}
+	return executions
+}
+
+// Tickerの更新に合わせて他の参加者の約定を1件生成する(最終取引価格が上がった場合は買い、下がった場合は売り)
+func (s *Server) addMarketExecution(previous, current *bitflyer.Ticker) {
+	side := "BUY"
+	if current.Ltp < previous.Ltp {
+		side = "SELL"
+	}
+	size := math.Max(math.Round(s.random.ExpFloat64()*1e6)/1e8, 0.001)
+	s.addExecution(current.ProductCode, side, current.Ltp, size, "", "")
+}
+
+// 自分の注文の約定を記録する(手数料はコインの数量で記録する)
+func (s *Server) addMyExecution(order *bitflyer.Order, price float64, commission float64) {
+	buyID, sellID := order.ChildOrderAcceptanceID, ""
+	if order.Side == "SELL" {
+		buyID, sellID = "", order.ChildOrderAcceptanceID
+	}
+	execution := s.addExecution(order.ProductCode, order.Side, price, order.Size, buyID, sellID)
+	s.myExecutions = append(s.myExecutions, myExecution{
+		MyExecution: bitflyer.MyExecution{
+			ID:                     execution.ID,
+			ChildOrderID:           order.ChildOrderID,
+			Side:                   order.Side,
+			Price:                  price,
+			Size:                   order.Size,
+			Commission:             commission,
+			ExecDate:               execution.ExecDate,
+			ChildOrderAcceptanceID: order.ChildOrderAcceptanceID,
+		},
+		productCode: order.ProductCode,
+	})
+}
+
+// 証拠金取引の注文を約定させて建玉を更新する(反対売買で決済した分の損益は証拠金に反映する)
+func (s *Server) executeMargin(order *bitflyer.Order, price float64) {
+	position := s.positions[order.ProductCode]
+	if position == nil {
+		position = &bitflyer.Position{ProductCode: order.ProductCode, Side: order.Side, Leverage: leverage}
+		s.positions[order.ProductCode] = position
+	}
+
+	size := order.Size
+	if position.Size > 0 && position.Side != order.Side {
+		closed := math.Min(position.Size, size)
+		pnl := (price - position.Price) * closed
+		if position.Side == "SELL" {
+			pnl = -pnl
+		}
+		s.addBalance("JPY", pnl)
+		position.Size -= closed
+		size -= closed
+	}
+	if size > 0 {
+		if position.Size == 0 {
+			position.Side = order.Side
+			position.Price = 0
+		}
+		position.Price = (position.Price*position.Size + price*size) / (position.Size + size)
+		position.Size += size
+	}
+	if position.Size <= 0 {
+		delete(s.positions, order.ProductCode)
+		return
+	}
+	position.RequireCollateral = position.Price * position.Size / leverage
+	position.OpenDate = time.Now().UTC().Format("2006-01-02T15:04:05.000")
+}
+
+// 建玉の評価損益を計算する
+func (s *Server) positionPnl(position *bitflyer.Position) float64 {
+	ticker, ok := s.tickers[position.ProductCode]
+	if !ok {
+		return 0
+	}
+	if position.Side == "SELL" {
+		return (position.Price - ticker.BestAsk) * position.Size
+	}
+	return (ticker.BestBid - position.Price) * position.Size
+}
+
+func (s *Server) collateral() bitflyer.Collateral {
+	collateral := bitflyer.Collateral{Collateral: s.available("JPY")}
+	for _, position := range s.positions {
+		collateral.OpenPositionPnl += s.positionPnl(position)
+		collateral.RequireCollateral += position.RequireCollateral
+	}
+	if collateral.RequireCollateral > 0 {
+		collateral.KeepRate = (collateral.Collateral + collateral.OpenPositionPnl) / collateral.RequireCollateral
+	}
+	return collateral
+}
+
+// 証拠金取引の注文に必要な証拠金があるかを確認する(反対売買で決済する分は不要)
+func (s *Server) hasEnoughCollateral(productCode, side string, price, size float64) bool {
+	if position := s.positions[productCode]; position != nil && position.Side != side {
+		size -= position.Size
+	}
+	if size <= 0 {
+		return true
+	}
+	collateral := s.collateral()
+	return collateral.Available() >= price*size/leverage
+}
+
+// GET /v1/executions
+// product_code, count, before, after で絞り込み、新しい順に返す
+func (s *Server) handleExecutions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	productCode := query.Get("product_code")
+	if productCode == "" {
+		productCode = s.config.ProductCodes[0]
+	}
+	count, before, after := pagingQuery(query)
+
+	s.mu.Lock()
+	_, ok := s.tickers[productCode]
+	executions := []bitflyer.Execution{}
+	all := s.executions[productCode]
+	for i := len(all) - 1; i >= 0 && len(executions) < count; i-- {
+		if all[i].ID < before && all[i].ID > after {
+			executions = append(executions, all[i])
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+		return
+	}
+	writeJSON(w, executions)
+}
+
+// GET /v1/me/getexecutions
+// product_code, count, before, after, child_order_id, child_order_acceptance_id で絞り込み、新しい順に返す
+func (s *Server) handleGetExecutions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count, before, after := pagingQuery(query)
+
+	s.mu.Lock()
+	executions := []bitflyer.MyExecution{}
+	for i := len(s.myExecutions) - 1; i >= 0 && len(executions) < count; i-- {
+		execution := s.myExecutions[i]
+		if execution.ID >= before || execution.ID <= after ||
+			!matchQuery(query, "product_code", execution.productCode) ||
+			!matchQuery(query, "child_order_id", execution.ChildOrderID) ||
+			!matchQuery(query, "child_order_acceptance_id", execution.ChildOrderAcceptanceID) {
+			continue
+		}
+		executions = append(executions, execution.MyExecution)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, executions)
+}
+
+// GET /v1/me/getpositions
+func (s *Server) handleGetPositions(w http.ResponseWriter, r *http.Request) {
+	productCode := r.URL.Query().Get("product_code")
+	if !isMarginProduct(productCode) {
+		writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+		return
+	}
+
+	s.mu.Lock()
+	positions := []bitflyer.Position{}
+	if position, ok := s.positions[productCode]; ok {
+		p := *position
+		p.Pnl = s.positionPnl(position)
+		positions = append(positions, p)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, positions)
+}
+
+// GET /v1/me/getcollateral
+func (s *Server) handleGetCollateral(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	collateral := s.collateral()
+	s.mu.Unlock()
+
+	writeJSON(w, collateral)
+}
+
+// GET /v1/me/gettradingcommission
+func (s *Server) handleGetTradingCommission(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, ok := s.tickers[r.URL.Query().Get("product_code")]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+		return
+	}
+	writeJSON(w, bitflyer.TradingCommission{CommissionRate: s.commissionRate(r.URL.Query().Get("product_code"))})
+}
+
+// 証拠金取引は手数料なし、現物はConfig.CommissionRateとする
+func (s *Server) commissionRate(productCode string) float64 {
+	if isMarginProduct(productCode) {
+		return 0
+	}
+	return s.config.CommissionRate
+}
+
+// count, before, after を読み込む(省略時は count=100 で全件を対象とする)
+func pagingQuery(query map[string][]string) (count, before, after int) {
+	get := func(key string) (int, bool) {
+		values := query[key]
+		if len(values) == 0 {
+			return 0, false
+		}
+		value, err := strconv.Atoi(values[0])
+		return value, err == nil
+	}
+	count, ok := get("count")
+	if !ok || count <= 0 {
+		count = 100
+	}
+	before, ok = get("before")
+	if !ok || before <= 0 {
+		before = math.MaxInt
+	}
+	after, _ = get("after")
+	return count, before, after
+}
@@ -0,0 +1,124 @@
+package mockserver
+
+import (
+	"fmt"
+	"gotrading/bitflyer"
+	"math"
+	"net/http"
+)
+
+// 板の片側あたりの価格の数
+const boardDepth = 50
+
+// スナップショットを配信する間隔(Tickerの更新回数)
+const boardSnapshotInterval = 10
+
+// Tickerの最良気配を起点に板を生成する
+// 前回の板にある価格は数量を一部だけ入れ替え、差分が少しずつ変化するようにする
+func (s *Server) nextBoard(productCode string) *bitflyer.Board {
+	ticker := s.tickers[productCode]
+	previous := s.boards[productCode]
+	step := math.Max(math.Round(ticker.GetMidPrice()*0.0001), 1)
+
+	previousSizes := map[float64]float64{}
+	if previous != nil {
+		for _, level := range append(append([]bitflyer.BoardOrder{}, previous.Bids...), previous.Asks...) {
+			previousSizes[level.Price] = level.Size
+		}
+	}
+	size := func(price float64) float64 {
+		if size, ok := previousSizes[price]; ok && s.random.Float64() < 0.8 {
+			return size
+		}
+		return math.Round(s.random.ExpFloat64()*1e4) / 1e4
+	}
+
+	board := &bitflyer.Board{MidPrice: ticker.GetMidPrice()}
+	for i := 0; i < boardDepth; i++ {
+		bid := ticker.BestBid - float64(i)*step
+		ask := ticker.BestAsk + float64(i)*step
+		board.Bids = append(board.Bids, bitflyer.BoardOrder{Price: bid, Size: size(bid)})
+		board.Asks = append(board.Asks, bitflyer.BoardOrder{Price: ask, Size: size(ask)})
+	}
+	// 最良気配の数量はTickerに合わせる
+	board.Bids[0].Size = math.Max(ticker.BestBidSize, 0.0001)
+	board.Asks[0].Size = math.Max(ticker.BestAskSize, 0.0001)
+	return board
+}
+
+// 2つの板の差分を求める(なくなった価格は数量0として含める)
+func diffBoard(previous, current *bitflyer.Board) *bitflyer.Board {
+	diff := &bitflyer.Board{MidPrice: current.MidPrice, Bids: []bitflyer.BoardOrder{}, Asks: []bitflyer.BoardOrder{}}
+	if previous == nil {
+		diff.Bids, diff.Asks = current.Bids, current.Asks
+		return diff
+	}
+	diff.Bids = diffLevels(previous.Bids, current.Bids)
+	diff.Asks = diffLevels(previous.Asks, current.Asks)
+	return diff
+}
+
+func diffLevels(previous, current []bitflyer.BoardOrder) []bitflyer.BoardOrder {
+	sizes := map[float64]float64{}
+	for _, level := range current {
+		sizes[level.Price] = level.Size
+	}
+	levels := []bitflyer.BoardOrder{}
+	for _, level := range previous {
+		if _, ok := sizes[level.Price]; !ok {
+			levels = append(levels, bitflyer.BoardOrder{Price: level.Price, Size: 0})
+		}
+	}
+	previousSizes := map[float64]float64{}
+	for _, level := range previous {
+		previousSizes[level.Price] = level.Size
+	}
+	for _, level := range current {
+		if size, ok := previousSizes[level.Price]; !ok || size != level.Size {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// 板を更新し、配信するスナップショットまたは差分を返す
+func (s *Server) updateBoard(productCode string) (channel string, message *bitflyer.Board) {
+	previous := s.boards[productCode]
+	board := s.nextBoard(productCode)
+	s.boards[productCode] = board
+	s.boardUpdates[productCode]++
+	if s.boardUpdates[productCode]%boardSnapshotInterval == 0 {
+		return fmt.Sprintf("lightning_board_snapshot_%s", productCode), board
+	}
+	return fmt.Sprintf("lightning_board_%s", productCode), diffBoard(previous, board)
+}
+
+// 購読を開始したクライアントに現在の板のスナップショットを送信する
+func (s *Server) boardSnapshot(channel string) (*bitflyer.Board, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for productCode, board := range s.boards {
+		if channel == fmt.Sprintf("lightning_board_snapshot_%s", productCode) {
+			return board, true
+		}
+	}
+	return nil, false
+}
+
+// GET /v1/board
+func (s *Server) handleBoard(w http.ResponseWriter, r *http.Request) {
+	productCode := r.URL.Query().Get("product_code")
+	if productCode == "" {
+		productCode = s.config.ProductCodes[0]
+	}
+
+	s.mu.Lock()
+	board, ok := s.boards[productCode]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+		return
+	}
+	writeJSON(w, board)
+}
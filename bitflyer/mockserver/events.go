@@ -0,0 +1,81 @@
+package mockserver
+
+import (
+	"gotrading/bitflyer"
+	"time"
+)
+
+// 配信待ちのPrivate Channelのメッセージ
+// イベントはロックを取得した状態で発生するため、一旦溜めておきロックを解放してから配信する
+type pendingEvent struct {
+	channel string
+	message interface{}
+}
+
+func eventDate() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// 注文のイベントを配信待ちに追加する
+func (s *Server) queueChildOrderEvent(order *bitflyer.Order, eventType string) {
+	event := bitflyer.ChildOrderEvent{
+		ProductCode:            order.ProductCode,
+		ChildOrderID:           order.ChildOrderID,
+		ChildOrderAcceptanceID: order.ChildOrderAcceptanceID,
+		EventDate:              eventDate(),
+		EventType:              eventType,
+	}
+	switch eventType {
+	case bitflyer.EventOrder:
+		event.ChildOrderType = order.ChildOrderType
+		event.ExpireDate = order.ExpireDate
+		event.Side = order.Side
+		event.Price = order.Price
+		event.Size = order.Size
+	case bitflyer.EventExecution:
+		event.ExecID = s.executionID
+		event.Side = order.Side
+		event.Price = order.AveragePrice
+		event.Size = order.ExecutedSize
+		event.Commission = order.TotalCommission
+		event.OutstandingSize = order.OutstandingSize
+	}
+	s.pendingEvents = append(s.pendingEvents, pendingEvent{bitflyer.ChannelChildOrderEvents, []bitflyer.ChildOrderEvent{event}})
+}
+
+// 特殊注文のイベントを配信待ちに追加する
+func (s *Server) queueParentOrderEvent(p *parentOrder, eventType string, parameterIndex int, child *bitflyer.Order) {
+	event := bitflyer.ParentOrderEvent{
+		ProductCode:             p.summary.ProductCode,
+		ParentOrderID:           p.detail.ParentOrderID,
+		ParentOrderAcceptanceID: p.detail.ParentOrderAcceptanceID,
+		EventDate:               eventDate(),
+		EventType:               eventType,
+		ParameterIndex:          parameterIndex,
+	}
+	switch eventType {
+	case bitflyer.EventOrder:
+		event.ParentOrderType = p.detail.OrderMethod
+		event.ExpireDate = p.detail.ExpireDate
+	case bitflyer.EventTrigger:
+		event.ChildOrderType = child.ChildOrderType
+		event.ChildOrderAcceptanceID = child.ChildOrderAcceptanceID
+		event.Side = child.Side
+		event.Price = child.Price
+		event.Size = child.Size
+		event.ExpireDate = child.ExpireDate
+	}
+	s.pendingEvents = append(s.pendingEvents, pendingEvent{bitflyer.ChannelParentOrderEvents, []bitflyer.ParentOrderEvent{event}})
+}
+
+// 配信待ちのイベントを購読しているクライアントに配信する(ロックを取得していない状態で呼び出すこと)
+func (s *Server) flushEvents() {
+	s.mu.Lock()
+	events := s.pendingEvents
+	s.pendingEvents = nil
+	s.mu.Unlock()
+
+	for _, event := range events {
+		s.publish(event.channel, event.message)
+	}
+}
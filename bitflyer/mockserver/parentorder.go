@@ -0,0 +1,299 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"gotrading/bitflyer"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 特殊注文の状態を保持する構造体を定義
+type parentOrder struct {
+	detail  bitflyer.ParentOrderDetail
+	summary bitflyer.ParentOrderSummary
+	active  []int           // 現在執行中の注文(parametersのインデックス)
+	limits  map[int]bool    // STOP_LIMITのうちtrigger_priceに到達して指値になった注文
+	extreme map[int]float64 // TRAILの基準となる最良価格
+}
+
+// POST /v1/me/sendparentorder
+func (s *Server) handleSendParentOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, -1, "Method not allowed")
+		return
+	}
+	var order bitflyer.ParentOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, http.StatusBadRequest, -100, "Invalid JSON")
+		return
+	}
+	if err := order.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, -100, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range order.Parameters {
+		if _, ok := s.tickers[p.ProductCode]; !ok {
+			writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+			return
+		}
+	}
+
+	s.orderID++
+	date := time.Now().UTC()
+	first := order.Parameters[0]
+	p := &parentOrder{
+		detail: bitflyer.ParentOrderDetail{
+			ID:                      s.orderID,
+			ParentOrderID:           fmt.Sprintf("JCO%s-%06d", date.Format("20060102-150405"), s.orderID),
+			OrderMethod:             order.OrderMethod,
+			ExpireDate:              date.AddDate(0, 0, 30).Format("2006-01-02T15:04:05"),
+			TimeInForce:             order.TimeInForce,
+			Parameters:              order.Parameters,
+			ParentOrderAcceptanceID: fmt.Sprintf("JRF%s-%06d", date.Format("20060102-150405"), s.orderID),
+		},
+		limits:  map[int]bool{},
+		extreme: map[int]float64{},
+	}
+	p.summary = bitflyer.ParentOrderSummary{
+		ID:                      p.detail.ID,
+		ParentOrderID:           p.detail.ParentOrderID,
+		ProductCode:             first.ProductCode,
+		Side:                    first.Side,
+		ParentOrderType:         order.OrderMethod,
+		Price:                   first.Price,
+		Size:                    first.Size,
+		ParentOrderState:        "ACTIVE",
+		ExpireDate:              p.detail.ExpireDate,
+		ParentOrderDate:         date.Format("2006-01-02T15:04:05"),
+		ParentOrderAcceptanceID: p.detail.ParentOrderAcceptanceID,
+		OutstandingSize:         first.Size,
+	}
+	if order.OrderMethod == bitflyer.OrderMethodOCO {
+		p.activate(0, 1)
+	} else {
+		p.activate(0)
+	}
+	s.parentOrders = append(s.parentOrders, p)
+	s.queueParentOrderEvent(p, bitflyer.EventOrder, 0, nil)
+	s.executeParentOrder(p)
+
+	writeJSON(w, bitflyer.ResponseSendParentOrder{ParentOrderAcceptanceID: p.detail.ParentOrderAcceptanceID})
+}
+
+// 注文の執行を開始する(TRAILの基準価格は執行開始時点の価格から追跡する)
+func (p *parentOrder) activate(indexes ...int) {
+	p.active = indexes
+	for _, i := range indexes {
+		delete(p.extreme, i)
+	}
+}
+
+// 執行中の特殊注文の条件を判定し、条件を満たした注文を約定させる
+func (s *Server) fillParentOrders() {
+	for _, p := range s.parentOrders {
+		if p.summary.ParentOrderState == "ACTIVE" {
+			s.executeParentOrder(p)
+		}
+	}
+}
+
+func (s *Server) executeParentOrder(p *parentOrder) {
+	for _, i := range p.active {
+		param := p.detail.Parameters[i]
+		price, ok := s.triggered(p, i)
+		if !ok || !s.hasEnoughBalance(param.ProductCode, param.Side, price, param.Size) {
+			continue
+		}
+		s.fillParameter(p, i, param, price)
+
+		// 約定した注文に応じて次の注文に進む(OCOの場合はもう一方の注文を取り消す)
+		switch {
+		case p.detail.OrderMethod == bitflyer.OrderMethodIFD && i == 0:
+			p.activate(1)
+		case p.detail.OrderMethod == bitflyer.OrderMethodIFDOCO && i == 0:
+			p.activate(1, 2)
+		default:
+			p.active = nil
+			p.summary.ParentOrderState = "COMPLETED"
+			s.queueParentOrderEvent(p, bitflyer.EventComplete, i, nil)
+		}
+		// 次の注文が即時に約定する場合もあるため、続けて判定する
+		if p.summary.ParentOrderState == "ACTIVE" {
+			s.executeParentOrder(p)
+		}
+		return
+	}
+}
+
+// 注文の執行条件を満たしているかを判定し、満たしている場合は約定価格を返す
+func (s *Server) triggered(p *parentOrder, i int) (float64, bool) {
+	param := p.detail.Parameters[i]
+	ticker := s.tickers[param.ProductCode]
+	buy := param.Side == "BUY"
+	market := ticker.BestBid
+	if buy {
+		market = ticker.BestAsk
+	}
+	reachedLimit := func() bool {
+		return buy && ticker.BestAsk <= param.Price || !buy && ticker.BestBid >= param.Price
+	}
+	reachedTrigger := func() bool {
+		return buy && ticker.BestAsk >= param.TriggerPrice || !buy && ticker.BestBid <= param.TriggerPrice
+	}
+
+	switch param.ConditionType {
+	case bitflyer.ConditionMarket:
+		return market, true
+	case bitflyer.ConditionLimit:
+		return param.Price, reachedLimit()
+	case bitflyer.ConditionStop:
+		return market, reachedTrigger()
+	case bitflyer.ConditionStopLimit:
+		if !p.limits[i] && reachedTrigger() {
+			p.limits[i] = true
+		}
+		return param.Price, p.limits[i] && reachedLimit()
+	case bitflyer.ConditionTrail:
+		// 売りは最高値から、買いは最安値からoffset分戻ったら成行で約定する
+		extreme, ok := p.extreme[i]
+		if !ok {
+			extreme = market
+		}
+		if buy {
+			extreme = math.Min(extreme, market)
+		} else {
+			extreme = math.Max(extreme, market)
+		}
+		p.extreme[i] = extreme
+		if buy {
+			return market, market >= extreme+param.Offset
+		}
+		return market, market <= extreme-param.Offset
+	}
+	return 0, false
+}
+
+// 約定した注文を子注文として記録し、残高を更新する
+func (s *Server) fillParameter(p *parentOrder, index int, param bitflyer.ParentOrderParameter, price float64) {
+	s.orderID++
+	date := time.Now().UTC()
+	order := bitflyer.Order{
+		ID:                     s.orderID,
+		ChildOrderID:           fmt.Sprintf("JOR%s-%06d", date.Format("20060102-150405"), s.orderID),
+		ChildOrderAcceptanceID: fmt.Sprintf("JRF%s-%06d", date.Format("20060102-150405"), s.orderID),
+		ProductCode:            param.ProductCode,
+		ChildOrderType:         "MARKET",
+		Side:                   param.Side,
+		Size:                   param.Size,
+		ChildOrderDate:         date.Format("2006-01-02T15:04:05"),
+		ExpireDate:             p.detail.ExpireDate,
+		TimeInForce:            p.detail.TimeInForce,
+	}
+	if param.ConditionType == bitflyer.ConditionLimit || param.ConditionType == bitflyer.ConditionStopLimit {
+		order.ChildOrderType = "LIMIT"
+		order.Price = param.Price
+	}
+	order.ChildOrderState = "ACTIVE"
+	order.OutstandingSize = order.Size
+	s.queueParentOrderEvent(p, bitflyer.EventTrigger, index, &order)
+	s.queueChildOrderEvent(&order, bitflyer.EventOrder)
+	s.execute(&order, price)
+	s.orders = append(s.orders, order)
+
+	// 一覧の約定価格・数量は最初に約定した注文の値とする
+	if p.summary.ExecutedSize == 0 {
+		p.summary.AveragePrice = price
+		p.summary.ExecutedSize = param.Size
+		p.summary.OutstandingSize = 0
+	}
+}
+
+// GET /v1/me/getparentorders
+// product_code, parent_order_state, count で絞り込み、新しい順に返す
+func (s *Server) handleGetParentOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil || count <= 0 {
+		count = 100
+	}
+
+	s.mu.Lock()
+	orders := []bitflyer.ParentOrderSummary{}
+	for i := len(s.parentOrders) - 1; i >= 0 && len(orders) < count; i-- {
+		summary := s.parentOrders[i].summary
+		if !matchQuery(query, "product_code", summary.ProductCode) ||
+			!matchQuery(query, "parent_order_state", summary.ParentOrderState) {
+			continue
+		}
+		orders = append(orders, summary)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, orders)
+}
+
+// GET /v1/me/getparentorder
+func (s *Server) handleGetParentOrder(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.mu.Lock()
+	p := s.findParentOrder(query.Get("parent_order_id"), query.Get("parent_order_acceptance_id"))
+	var detail bitflyer.ParentOrderDetail
+	if p != nil {
+		detail = p.detail
+	}
+	s.mu.Unlock()
+
+	if p == nil {
+		writeError(w, http.StatusBadRequest, -111, "Order not found")
+		return
+	}
+	writeJSON(w, detail)
+}
+
+// POST /v1/me/cancelparentorder
+func (s *Server) handleCancelParentOrder(w http.ResponseWriter, r *http.Request) {
+	var cancel bitflyer.CancelParentOrder
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&cancel) != nil {
+		writeError(w, http.StatusBadRequest, -100, "Invalid request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.findParentOrder(cancel.ParentOrderID, cancel.ParentOrderAcceptanceID)
+	if p != nil && p.summary.ProductCode == cancel.ProductCode {
+		s.cancelParentOrder(p)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) cancelParentOrder(p *parentOrder) {
+	if p.summary.ParentOrderState != "ACTIVE" {
+		return
+	}
+	p.active = nil
+	p.summary.CancelSize = p.summary.OutstandingSize
+	p.summary.OutstandingSize = 0
+	p.summary.ParentOrderState = "CANCELED"
+	s.queueParentOrderEvent(p, bitflyer.EventCancel, 0, nil)
+}
+
+func (s *Server) findParentOrder(parentOrderID, parentOrderAcceptanceID string) *parentOrder {
+	if parentOrderID == "" && parentOrderAcceptanceID == "" {
+		return nil
+	}
+	for _, p := range s.parentOrders {
+		if (parentOrderID != "" && p.detail.ParentOrderID == parentOrderID) ||
+			(parentOrderAcceptanceID != "" && p.detail.ParentOrderAcceptanceID == parentOrderAcceptanceID) {
+			return p
+		}
+	}
+	return nil
+}
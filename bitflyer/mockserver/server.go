@@ -0,0 +1,527 @@
+// bitFlyer Lightning APIのモックサーバー
+// ネットワーク接続や本物のAPIキーがなくてもアプリケーション全体を動作させるために使用する
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"gotrading/bitflyer"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// モックサーバーの設定を定義
+type Config struct {
+	ProductCodes   []string                     // 配信する銘柄 ex) BTC_JPY
+	InitialPrices  map[string]float64           // 銘柄ごとの初期価格(省略時はdefaultInitialPrice)
+	Balances       map[string]float64           // 通貨ごとの初期残高 ex) JPY => 1000000
+	Interval       time.Duration                // Tickerを更新する間隔
+	Volatility     float64                      // ランダムウォークの1回あたりの変動率(標準偏差)
+	Spread         float64                      // best_bidとbest_askの価格差の割合
+	Script         map[string][]bitflyer.Ticker // 銘柄ごとに順番に配信するTicker(指定した銘柄はランダムウォークの代わりに使用)
+	CommissionRate float64                      // 現物取引の手数料率(証拠金取引は手数料なし)
+	Seed           int64
+}
+
+const defaultInitialPrice = 5000000
+
+// モックサーバーの状態を保持する構造体を定義
+type Server struct {
+	config Config
+
+	mu                   sync.Mutex
+	random               *rand.Rand
+	tickers              map[string]*bitflyer.Ticker
+	scriptIndex          map[string]int
+	balances             map[string]*bitflyer.Balance
+	orders               []bitflyer.Order
+	parentOrders         []*parentOrder
+	executionID          int
+	executions           map[string][]bitflyer.Execution // 銘柄ごとの約定履歴
+	publishedExecutionID map[string]int                  // 銘柄ごとのRealtime APIで配信済みの約定のID
+	myExecutions         []myExecution
+	positions            map[string]*bitflyer.Position // 証拠金取引の銘柄ごとの建玉
+	boards               map[string]*bitflyer.Board
+	boardUpdates         map[string]int
+	orderID              int
+	subscribers          map[*subscriber]bool
+	pendingEvents        []pendingEvent
+}
+
+// 設定を元にモックサーバーを生成するコンストラクタ
+func New(config Config) *Server {
+	if len(config.ProductCodes) == 0 {
+		config.ProductCodes = []string{"BTC_JPY"}
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.Volatility <= 0 {
+		config.Volatility = 0.0005
+	}
+	if config.Spread <= 0 {
+		config.Spread = 0.0002
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	s := &Server{
+		config:               config,
+		random:               rand.New(rand.NewSource(config.Seed)),
+		tickers:              map[string]*bitflyer.Ticker{},
+		scriptIndex:          map[string]int{},
+		balances:             map[string]*bitflyer.Balance{},
+		executions:           map[string][]bitflyer.Execution{},
+		publishedExecutionID: map[string]int{},
+		positions:            map[string]*bitflyer.Position{},
+		boards:               map[string]*bitflyer.Board{},
+		boardUpdates:         map[string]int{},
+		subscribers:          map[*subscriber]bool{},
+	}
+	for currencyCode, amount := range config.Balances {
+		s.balances[currencyCode] = &bitflyer.Balance{CurrentCode: currencyCode, Amount: amount, Available: amount}
+	}
+	for _, productCode := range config.ProductCodes {
+		price, ok := config.InitialPrices[productCode]
+		if !ok {
+			price = defaultInitialPrice
+		}
+		s.tickers[productCode] = s.newTicker(productCode, price, 0)
+		s.nextTicker(productCode)
+		s.boards[productCode] = s.nextBoard(productCode)
+	}
+	return s
+}
+
+// モックサーバーのエンドポイントを定義
+// REST APIは /v1/ 以下、Realtime APIは /json-rpc で提供する
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ticker", s.handleTicker)
+	mux.HandleFunc("/v1/executions", s.handleExecutions)
+	mux.HandleFunc("/v1/board", s.handleBoard)
+	mux.HandleFunc("/v1/me/getbalance", s.private(s.handleGetBalance))
+	mux.HandleFunc("/v1/me/sendchildorder", s.private(s.handleSendChildOrder))
+	mux.HandleFunc("/v1/me/getchildorders", s.private(s.handleGetChildOrders))
+	mux.HandleFunc("/v1/me/cancelchildorder", s.private(s.handleCancelChildOrder))
+	mux.HandleFunc("/v1/me/cancelallchildorders", s.private(s.handleCancelAllChildOrders))
+	mux.HandleFunc("/v1/me/sendparentorder", s.private(s.handleSendParentOrder))
+	mux.HandleFunc("/v1/me/getparentorders", s.private(s.handleGetParentOrders))
+	mux.HandleFunc("/v1/me/getparentorder", s.private(s.handleGetParentOrder))
+	mux.HandleFunc("/v1/me/cancelparentorder", s.private(s.handleCancelParentOrder))
+	mux.HandleFunc("/v1/me/getexecutions", s.private(s.handleGetExecutions))
+	mux.HandleFunc("/v1/me/getpositions", s.private(s.handleGetPositions))
+	mux.HandleFunc("/v1/me/getcollateral", s.private(s.handleGetCollateral))
+	mux.HandleFunc("/v1/me/gettradingcommission", s.private(s.handleGetTradingCommission))
+	mux.HandleFunc("/json-rpc", s.handleWebSocket)
+	return mux
+}
+
+// Tickerの更新を開始し、指定したアドレスでモックサーバーを起動する
+func (s *Server) ListenAndServe(addr string) error {
+	go s.Run()
+	log.Printf("action=mockserver addr=%s", addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// Interval毎に全ての銘柄のTickerを更新し、購読しているクライアントに配信する
+func (s *Server) Run() {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.Tick()
+	}
+}
+
+// 全ての銘柄のTickerを1回だけ更新して配信する
+func (s *Server) Tick() {
+	s.mu.Lock()
+	updated := make([]bitflyer.Ticker, 0, len(s.config.ProductCodes))
+	for _, productCode := range s.config.ProductCodes {
+		previous := s.tickers[productCode]
+		s.nextTicker(productCode)
+		s.addMarketExecution(previous, s.tickers[productCode])
+		s.fillLimitOrders(productCode)
+	}
+	s.fillParentOrders()
+	boards := map[string]*bitflyer.Board{}
+	executions := map[string][]bitflyer.Execution{}
+	for _, productCode := range s.config.ProductCodes {
+		updated = append(updated, *s.tickers[productCode])
+		channel, board := s.updateBoard(productCode)
+		boards[channel] = board
+		executions[fmt.Sprintf("lightning_executions_%s", productCode)] = s.unpublishedExecutions(productCode)
+	}
+	s.mu.Unlock()
+
+	for _, t := range updated {
+		s.publish(fmt.Sprintf("lightning_ticker_%s", t.ProductCode), t)
+	}
+	for channel, execution := range executions {
+		s.publish(channel, execution)
+	}
+	for channel, board := range boards {
+		s.publish(channel, board)
+	}
+	s.flushEvents()
+}
+
+// 次のTickerを生成する(スクリプトがある場合はスクリプトの順番に、ない場合はランダムウォークで生成)
+func (s *Server) nextTicker(productCode string) {
+	current := s.tickers[productCode]
+	if script := s.config.Script[productCode]; len(script) > 0 {
+		index := s.scriptIndex[productCode] % len(script)
+		s.scriptIndex[productCode]++
+		next := script[index]
+		next.ProductCode = productCode
+		if next.Timestamp == "" {
+			next.Timestamp = now()
+		}
+		next.TickID = current.TickID + 1
+		s.tickers[productCode] = &next
+		return
+	}
+
+	mid := current.GetMidPrice() * math.Exp(s.random.NormFloat64()*s.config.Volatility)
+	next := s.newTicker(productCode, mid, current.TickID+1)
+	next.Volume = current.Volume + next.Volume
+	next.VolumeByProduct = next.Volume
+	s.tickers[productCode] = next
+}
+
+func (s *Server) newTicker(productCode string, mid float64, tickID int) *bitflyer.Ticker {
+	halfSpread := math.Max(math.Round(mid*s.config.Spread/2), 1)
+	mid = math.Round(mid)
+	volume := math.Round(s.random.ExpFloat64()*1e6) / 1e8
+	return &bitflyer.Ticker{
+		ProductCode:     productCode,
+		Timestamp:       now(),
+		TickID:          tickID,
+		BestBid:         mid - halfSpread,
+		BestAsk:         mid + halfSpread,
+		BestBidSize:     math.Round(s.random.Float64()*1e4) / 1e4,
+		BestAskSize:     math.Round(s.random.Float64()*1e4) / 1e4,
+		TotalBidDepth:   1000,
+		TotalAskDepth:   1000,
+		Ltp:             mid,
+		Volume:          volume,
+		VolumeByProduct: volume,
+	}
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// GET /v1/ticker
+func (s *Server) handleTicker(w http.ResponseWriter, r *http.Request) {
+	productCode := r.URL.Query().Get("product_code")
+	if productCode == "" {
+		productCode = s.config.ProductCodes[0]
+	}
+
+	s.mu.Lock()
+	ticker, ok := s.tickers[productCode]
+	var t bitflyer.Ticker
+	if ok {
+		t = *ticker
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+		return
+	}
+	writeJSON(w, t)
+}
+
+// Private APIは署名の検証は行わず、認証ヘッダーが付与されているかのみ確認する
+func (s *Server) private(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("ACCESS-KEY") == "" || r.Header.Get("ACCESS-SIGN") == "" {
+			writeError(w, http.StatusUnauthorized, -500, "Key not found")
+			return
+		}
+		fn(w, r)
+		s.flushEvents()
+	}
+}
+
+// GET /v1/me/getbalance
+func (s *Server) handleGetBalance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	balances := make([]bitflyer.Balance, 0, len(s.balances))
+	for _, balance := range s.balances {
+		balances = append(balances, *balance)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(balances, func(i, j int) bool { return balances[i].CurrentCode < balances[j].CurrentCode })
+	writeJSON(w, balances)
+}
+
+// POST /v1/me/sendchildorder
+// MARKETはその時点のbest_ask/best_bidで即時に約定し、LIMITは価格が到達した時点で約定する
+func (s *Server) handleSendChildOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, -1, "Method not allowed")
+		return
+	}
+	var order bitflyer.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		writeError(w, http.StatusBadRequest, -100, "Invalid JSON")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticker, ok := s.tickers[order.ProductCode]
+	switch {
+	case !ok:
+		writeError(w, http.StatusBadRequest, -100, "Invalid product_code")
+		return
+	case order.Side != "BUY" && order.Side != "SELL":
+		writeError(w, http.StatusBadRequest, -100, "Invalid side")
+		return
+	case order.ChildOrderType != "MARKET" && order.ChildOrderType != "LIMIT":
+		writeError(w, http.StatusBadRequest, -100, "Invalid child_order_type")
+		return
+	case order.Size <= 0:
+		writeError(w, http.StatusBadRequest, -110, "The minimum order size is 0.001 BTC")
+		return
+	case order.ChildOrderType == "LIMIT" && order.Price <= 0:
+		writeError(w, http.StatusBadRequest, -106, "The price is too low")
+		return
+	}
+
+	price := order.Price
+	if order.ChildOrderType == "MARKET" {
+		price = ticker.BestAsk
+		if order.Side == "SELL" {
+			price = ticker.BestBid
+		}
+	}
+	if !s.hasEnoughBalance(order.ProductCode, order.Side, price, order.Size) {
+		writeError(w, http.StatusBadRequest, -200, "Insufficient funds")
+		return
+	}
+
+	s.orderID++
+	date := time.Now().UTC()
+	order.ID = s.orderID
+	order.ChildOrderID = fmt.Sprintf("JOR%s-%06d", date.Format("20060102-150405"), s.orderID)
+	order.ChildOrderAcceptanceID = fmt.Sprintf("JRF%s-%06d", date.Format("20060102-150405"), s.orderID)
+	order.ChildOrderDate = date.Format("2006-01-02T15:04:05")
+	order.ExpireDate = date.AddDate(0, 0, 30).Format("2006-01-02T15:04:05")
+	order.ChildOrderState = "ACTIVE"
+	order.OutstandingSize = order.Size
+	s.queueChildOrderEvent(&order, bitflyer.EventOrder)
+	if order.ChildOrderType == "MARKET" {
+		order.Price = 0
+		s.execute(&order, price)
+	}
+	s.orders = append(s.orders, order)
+
+	writeJSON(w, bitflyer.ResponseSendChildOrder{ChildOrderAcceptanceID: order.ChildOrderAcceptanceID})
+}
+
+// LIMIT注文の価格に到達していれば約定させる
+func (s *Server) fillLimitOrders(productCode string) {
+	ticker := s.tickers[productCode]
+	for i := range s.orders {
+		order := &s.orders[i]
+		if order.ProductCode != productCode || order.ChildOrderState != "ACTIVE" {
+			continue
+		}
+		if order.Side == "BUY" && ticker.BestAsk <= order.Price ||
+			order.Side == "SELL" && ticker.BestBid >= order.Price {
+			if s.hasEnoughBalance(order.ProductCode, order.Side, order.Price, order.Size) {
+				s.execute(order, order.Price)
+			}
+		}
+	}
+}
+
+// 注文を約定させて残高(証拠金取引の場合は建玉)を更新する
+// 現物の手数料はコインの数量で計算し、買いの場合は受け取るコイン、売りの場合は受け取る通貨から差し引く
+func (s *Server) execute(order *bitflyer.Order, price float64) {
+	commission := order.Size * s.commissionRate(order.ProductCode)
+	if isMarginProduct(order.ProductCode) {
+		s.executeMargin(order, price)
+	} else {
+		coin, currency := splitProductCode(order.ProductCode)
+		amount := price * order.Size
+		if order.Side == "BUY" {
+			s.addBalance(currency, -amount)
+			s.addBalance(coin, order.Size-commission)
+		} else {
+			s.addBalance(coin, -order.Size)
+			s.addBalance(currency, amount-price*commission)
+		}
+	}
+	s.addMyExecution(order, price, commission)
+	order.AveragePrice = price
+	order.TotalCommission = commission
+	order.ExecutedSize = order.Size
+	order.OutstandingSize = 0
+	order.ChildOrderState = "COMPLETED"
+	s.queueChildOrderEvent(order, bitflyer.EventExecution)
+}
+
+func (s *Server) hasEnoughBalance(productCode, side string, price, size float64) bool {
+	if isMarginProduct(productCode) {
+		return s.hasEnoughCollateral(productCode, side, price, size)
+	}
+	coin, currency := splitProductCode(productCode)
+	if side == "BUY" {
+		return s.available(currency) >= price*size
+	}
+	return s.available(coin) >= size
+}
+
+func (s *Server) available(currencyCode string) float64 {
+	if balance, ok := s.balances[currencyCode]; ok {
+		return balance.Available
+	}
+	return 0
+}
+
+func (s *Server) addBalance(currencyCode string, amount float64) {
+	balance, ok := s.balances[currencyCode]
+	if !ok {
+		balance = &bitflyer.Balance{CurrentCode: currencyCode}
+		s.balances[currencyCode] = balance
+	}
+	balance.Amount += amount
+	balance.Available += amount
+}
+
+// ex) BTC_JPY => BTC, JPY / FX_BTC_JPY => BTC, JPY
+func splitProductCode(productCode string) (coin, currency string) {
+	codes := strings.Split(productCode, "_")
+	if len(codes) < 2 {
+		return productCode, "JPY"
+	}
+	return codes[len(codes)-2], codes[len(codes)-1]
+}
+
+// GET /v1/me/getchildorders
+// product_code, child_order_id, child_order_acceptance_id, child_order_state, count, before, after で絞り込み、新しい順に返す
+// before, afterはページングのid(注文のid)で、beforeより小さくafterより大きいidの注文を返す
+func (s *Server) handleGetChildOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil || count <= 0 {
+		count = 100
+	}
+	before, _ := strconv.Atoi(query.Get("before"))
+	after, _ := strconv.Atoi(query.Get("after"))
+
+	s.mu.Lock()
+	orders := []bitflyer.Order{}
+	for i := len(s.orders) - 1; i >= 0 && len(orders) < count; i-- {
+		order := s.orders[i]
+		if (before > 0 && order.ID >= before) || order.ID <= after {
+			continue
+		}
+		if !matchQuery(query, "product_code", order.ProductCode) ||
+			!matchQuery(query, "child_order_id", order.ChildOrderID) ||
+			!matchQuery(query, "child_order_acceptance_id", order.ChildOrderAcceptanceID) ||
+			!matchQuery(query, "child_order_state", order.ChildOrderState) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, orders)
+}
+
+// POST /v1/me/cancelchildorder
+func (s *Server) handleCancelChildOrder(w http.ResponseWriter, r *http.Request) {
+	var cancel bitflyer.CancelChildOrder
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&cancel) != nil {
+		writeError(w, http.StatusBadRequest, -100, "Invalid request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.orders {
+		order := &s.orders[i]
+		if order.ProductCode != cancel.ProductCode || order.ChildOrderState != "ACTIVE" {
+			continue
+		}
+		if (cancel.ChildOrderID != "" && order.ChildOrderID == cancel.ChildOrderID) ||
+			(cancel.ChildOrderAcceptanceID != "" && order.ChildOrderAcceptanceID == cancel.ChildOrderAcceptanceID) {
+			s.cancelOrder(order)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /v1/me/cancelallchildorders
+func (s *Server) handleCancelAllChildOrders(w http.ResponseWriter, r *http.Request) {
+	var cancel struct {
+		ProductCode string `json:"product_code"`
+	}
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&cancel) != nil {
+		writeError(w, http.StatusBadRequest, -100, "Invalid request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.orders {
+		order := &s.orders[i]
+		if order.ProductCode == cancel.ProductCode && order.ChildOrderState == "ACTIVE" {
+			s.cancelOrder(order)
+		}
+	}
+	// bitFlyerと同様に特殊注文も取り消す
+	for _, p := range s.parentOrders {
+		if p.summary.ProductCode == cancel.ProductCode {
+			s.cancelParentOrder(p)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) cancelOrder(order *bitflyer.Order) {
+	order.CancelSize = order.OutstandingSize
+	order.OutstandingSize = 0
+	order.ChildOrderState = "CANCELED"
+	s.queueChildOrderEvent(order, bitflyer.EventCancel)
+}
+
+func matchQuery(query map[string][]string, key, value string) bool {
+	values, ok := query[key]
+	return !ok || len(values) == 0 || values[0] == "" || values[0] == value
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("action=writeJSON err=%s", err.Error())
+	}
+}
+
+// bitFlyerと同じ形式のエラーレスポンスを返す
+func writeError(w http.ResponseWriter, httpStatus, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        status,
+		"error_message": message,
+		"data":          nil,
+	})
+}
@@ -0,0 +1,310 @@
+package mockserver
+
+import (
+	"context"
+	"gotrading/bitflyer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// モックサーバーを起動し、接続するAPIClientを返す
+// wrapを指定した場合はモックサーバーのハンドラーを包んで、通信の失敗などを再現する
+func newTestServer(t *testing.T, wrap func(next http.Handler) http.Handler, opts ...bitflyer.Option) (*Server, *bitflyer.APIClient) {
+	t.Helper()
+	s := New(Config{
+		ProductCodes: []string{"BTC_JPY"},
+		Balances:     map[string]float64{"JPY": 10000000},
+		Seed:         1,
+	})
+	handler := s.Handler()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	opts = append([]bitflyer.Option{
+		bitflyer.WithBaseURL(ts.URL + "/v1/"),
+		bitflyer.WithWebSocketURL("ws" + strings.TrimPrefix(ts.URL, "http") + "/json-rpc"),
+		bitflyer.WithRetryPolicy(bitflyer.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}),
+	}, opts...)
+	api := bitflyer.New("key", "secret", opts...)
+	return s, api
+}
+
+// 注文の送信(/v1/me/sendchildorder)のn回目の応答を差し替えるハンドラー
+// acceptがtrueの場合は注文を受け付けた上で応答だけ5xxにし、falseの場合は受け付けずに5xxを返す
+// othersを指定した場合は、受け付けた注文の後に別のプロセスが出した注文として数量の違う注文をothers件追加する
+type orderFault struct {
+	mu       sync.Mutex
+	attempts int
+	faults   map[int]bool // 送信回数 => accept
+	others   int
+}
+
+func (f *orderFault) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/me/sendchildorder" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f.mu.Lock()
+		f.attempts++
+		accept, fault := f.faults[f.attempts]
+		f.mu.Unlock()
+		if !fault {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if accept {
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			for i := 0; i < f.others; i++ {
+				other := httptest.NewRequest(http.MethodPost, r.URL.Path,
+					strings.NewReader(`{"product_code":"BTC_JPY","child_order_type":"MARKET","side":"BUY","size":0.001}`))
+				other.Header = r.Header.Clone()
+				next.ServeHTTP(httptest.NewRecorder(), other)
+			}
+		}
+		writeError(w, http.StatusInternalServerError, -1, "Internal server error")
+	})
+}
+
+func (f *orderFault) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attempts
+}
+
+func marketOrder(side string, size float64) *bitflyer.Order {
+	return &bitflyer.Order{
+		ProductCode:     "BTC_JPY",
+		ChildOrderType:  "MARKET",
+		Side:            side,
+		Size:            size,
+		MinuteToExpires: 1,
+		TimeInForce:     "GTC",
+	}
+}
+
+func listOrders(t *testing.T, api *bitflyer.APIClient) []bitflyer.Order {
+	t.Helper()
+	orders, err := api.ListOrder(map[string]string{"product_code": "BTC_JPY"})
+	if err != nil {
+		t.Fatalf("ListOrder: %v", err)
+	}
+	return orders
+}
+
+func TestSendOrder(t *testing.T) {
+	_, api := newTestServer(t, nil)
+
+	resp, err := api.SendOrder(marketOrder("BUY", 0.01))
+	if err != nil {
+		t.Fatalf("SendOrder: %v", err)
+	}
+	orders := listOrders(t, api)
+	if len(orders) != 1 || orders[0].ChildOrderAcceptanceID != resp.ChildOrderAcceptanceID {
+		t.Fatalf("orders = %+v, want the accepted order %s", orders, resp.ChildOrderAcceptanceID)
+	}
+	if orders[0].ChildOrderState != "COMPLETED" || orders[0].ExecutedSize != 0.01 {
+		t.Errorf("order state = %s executed = %f, want COMPLETED 0.01", orders[0].ChildOrderState, orders[0].ExecutedSize)
+	}
+
+	balances, err := api.GetBalance()
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	for _, balance := range balances {
+		if balance.CurrentCode == "BTC" && balance.Available != 0.01 {
+			t.Errorf("BTC available = %f, want 0.01", balance.Available)
+		}
+	}
+
+	if _, err := api.SendOrder(marketOrder("BUY", 100)); !bitflyer.IsInsufficientFunds(err) {
+		t.Errorf("err = %v, want insufficient funds", err)
+	}
+}
+
+// 注文の送信に失敗した場合は、受け付けられていないことを確認してから再送し、二重注文にならない
+func TestSendOrderRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		faults       map[int]bool
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"rejected then sent", map[int]bool{1: false}, false, 2},
+		{"accepted but response lost", map[int]bool{1: true}, false, 1},
+		{"accepted on the last attempt", map[int]bool{1: false, 2: false, 3: true}, false, 3},
+		{"never accepted", map[int]bool{1: false, 2: false, 3: false}, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fault := &orderFault{faults: tt.faults}
+			_, api := newTestServer(t, fault.wrap)
+
+			resp, err := api.SendOrder(marketOrder("BUY", 0.01))
+			if fault.count() != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", fault.count(), tt.wantAttempts)
+			}
+			orders := listOrders(t, api)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SendOrder succeeded with %s, want error", resp.ChildOrderAcceptanceID)
+				}
+				if len(orders) != 0 {
+					t.Errorf("orders = %d, want 0", len(orders))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SendOrder: %v", err)
+			}
+			if len(orders) != 1 || orders[0].ChildOrderAcceptanceID != resp.ChildOrderAcceptanceID {
+				t.Errorf("orders = %+v, want only %s", orders, resp.ChildOrderAcceptanceID)
+			}
+		})
+	}
+}
+
+// 受け付けた注文より新しい注文が1回のListOrderで取得できる数より多くても、遡って受け付けた注文を見つける
+func TestSendOrderRetryPagesOrders(t *testing.T) {
+	fault := &orderFault{faults: map[int]bool{1: true}, others: 150}
+	_, api := newTestServer(t, fault.wrap)
+
+	resp, err := api.SendOrder(marketOrder("BUY", 0.01))
+	if err != nil {
+		t.Fatalf("SendOrder: %v", err)
+	}
+	if fault.count() != 1 {
+		t.Errorf("attempts = %d, want 1", fault.count())
+	}
+	orders, err := api.ListOrder(map[string]string{"product_code": "BTC_JPY", "count": "1000"})
+	if err != nil {
+		t.Fatalf("ListOrder: %v", err)
+	}
+	var sent []string
+	for _, order := range orders {
+		if order.Size == 0.01 {
+			sent = append(sent, order.ChildOrderAcceptanceID)
+		}
+	}
+	if len(sent) != 1 || sent[0] != resp.ChildOrderAcceptanceID {
+		t.Errorf("sent orders = %v, want only %s", sent, resp.ChildOrderAcceptanceID)
+	}
+}
+
+// 送信を開始した時刻のClockSkew前より古い同じ内容の注文を、失敗した送信で受け付けられた注文と取り違えない
+func TestSendOrderRetryIgnoresOlderOrder(t *testing.T) {
+	fault := &orderFault{faults: map[int]bool{2: false, 3: false, 4: false}}
+	_, api := newTestServer(t, fault.wrap, bitflyer.WithRetryPolicy(bitflyer.RetryPolicy{
+		MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, ClockSkew: time.Second}))
+
+	existing, err := api.SendOrder(marketOrder("BUY", 0.01))
+	if err != nil {
+		t.Fatalf("SendOrder: %v", err)
+	}
+	// 注文の受付時刻は秒単位のため、ClockSkewと合わせて2秒より前の注文にする
+	time.Sleep(2100 * time.Millisecond)
+
+	resp, err := api.SendOrder(marketOrder("BUY", 0.01))
+	if err == nil {
+		t.Fatalf("SendOrder returned %s, want error (existing order %s)", resp.ChildOrderAcceptanceID, existing.ChildOrderAcceptanceID)
+	}
+	if orders := listOrders(t, api); len(orders) != 1 {
+		t.Errorf("orders = %d, want 1", len(orders))
+	}
+}
+
+// Realtime APIの注文のイベント(child_order_events)で、送信した注文の受付と約定を受信する
+func TestChildOrderEvents(t *testing.T) {
+	s, api := newTestServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan []bitflyer.ChildOrderEvent, 16)
+	stream := api.NewStream()
+	stream.SubscribeChildOrderEvents(events)
+	go stream.Run(ctx)
+	waitSubscribed(t, s, bitflyer.ChannelChildOrderEvents)
+
+	resp, err := api.SendOrder(marketOrder("BUY", 0.01))
+	if err != nil {
+		t.Fatalf("SendOrder: %v", err)
+	}
+
+	var eventTypes []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case received := <-events:
+			for _, event := range received {
+				if event.ChildOrderAcceptanceID != resp.ChildOrderAcceptanceID {
+					continue
+				}
+				eventTypes = append(eventTypes, event.EventType)
+				if event.IsFinal() {
+					if got := strings.Join(eventTypes, ","); got != "ORDER,EXECUTION" {
+						t.Errorf("events = %s, want ORDER,EXECUTION", got)
+					}
+					if event.Size != 0.01 || event.OutstandingSize != 0 {
+						t.Errorf("execution size = %f outstanding = %f, want 0.01 0", event.Size, event.OutstandingSize)
+					}
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for the final event (received %v)", eventTypes)
+		}
+	}
+}
+
+// Private Channelの購読が完了するまで待つ
+func waitSubscribed(t *testing.T, s *Server, channel string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		subscribers := make([]*subscriber, 0, len(s.subscribers))
+		for sub := range s.subscribers {
+			subscribers = append(subscribers, sub)
+		}
+		s.mu.Unlock()
+		for _, sub := range subscribers {
+			if sub.subscribed(channel) {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for subscription to %s", channel)
+}
+
+// 受信しない購読があっても、同じ接続の他のチャンネルの受信は止まらない
+func TestStreamSlowSubscriber(t *testing.T) {
+	s, api := newTestServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tickers := make(chan bitflyer.Ticker, 16)
+	blocked := make(chan []bitflyer.Execution)
+	stream := api.NewStream()
+	stream.SubscribeExecutions("BTC_JPY", blocked)
+	stream.SubscribeTicker("BTC_JPY", tickers)
+	go stream.Run(ctx)
+	waitSubscribed(t, s, "lightning_executions_BTC_JPY")
+	waitSubscribed(t, s, "lightning_ticker_BTC_JPY")
+
+	for i := 0; i < 5; i++ {
+		s.Tick()
+		select {
+		case <-tickers:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ticker %d was not received while the executions subscriber is blocked", i)
+		}
+	}
+}
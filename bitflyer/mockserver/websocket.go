@@ -0,0 +1,168 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"gotrading/bitflyer"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Realtime APIに接続しているクライアントを定義
+type subscriber struct {
+	conn          *websocket.Conn
+	mu            sync.Mutex // gorilla/websocketは同時に書き込みできないため排他制御を行う
+	channels      map[string]bool
+	authenticated bool // auth メソッドで認証済みかどうか(Private Channelの購読に必要)
+}
+
+// JSON-RPC 2.0 のエラーレスポンス
+type rpcError struct {
+	Version string `json:"jsonrpc"`
+	Error   struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Id *int `json:"id,omitempty"`
+}
+
+func (sub *subscriber) writeError(id *int, code int, message string) error {
+	response := rpcError{Version: "2.0", Id: id}
+	response.Error.Code = code
+	response.Error.Message = message
+	return sub.writeJSON(&response)
+}
+
+func (sub *subscriber) writeJSON(v interface{}) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.conn.WriteJSON(v)
+}
+
+func (sub *subscriber) subscribed(channel string) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.channels[channel]
+}
+
+// JSON-RPC 2.0 の auth / subscribe / unsubscribe を受け付ける
+// auth は署名の検証は行わず、api_key と signature が指定されているかのみ確認する
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("action=handleWebSocket err=%s", err.Error())
+		return
+	}
+	sub := &subscriber{conn: conn, channels: map[string]bool{}}
+
+	s.mu.Lock()
+	s.subscribers[sub] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var request struct {
+			Version string          `json:"jsonrpc"`
+			Method  string          `json:"method"`
+			Params  json.RawMessage `json:"params"`
+			Id      *int            `json:"id,omitempty"`
+		}
+		if err := conn.ReadJSON(&request); err != nil {
+			return
+		}
+
+		var params bitflyer.SubscribeParams
+		switch request.Method {
+		case "auth":
+			var auth struct {
+				APIKey    string `json:"api_key"`
+				Signature string `json:"signature"`
+			}
+			if err := json.Unmarshal(request.Params, &auth); err != nil || auth.APIKey == "" || auth.Signature == "" {
+				sub.writeError(request.Id, -32602, "Invalid params")
+				continue
+			}
+			sub.mu.Lock()
+			sub.authenticated = true
+			sub.mu.Unlock()
+		case "subscribe", "unsubscribe":
+			if err := json.Unmarshal(request.Params, &params); err != nil {
+				sub.writeError(request.Id, -32602, "Invalid params")
+				continue
+			}
+			sub.mu.Lock()
+			authenticated := sub.authenticated
+			sub.mu.Unlock()
+			if request.Method == "subscribe" && isPrivateChannel(params.Channel) && !authenticated {
+				sub.writeError(request.Id, -32600, "Authentication required")
+				continue
+			}
+			sub.mu.Lock()
+			if request.Method == "subscribe" {
+				sub.channels[params.Channel] = true
+			} else {
+				delete(sub.channels, params.Channel)
+			}
+			sub.mu.Unlock()
+		default:
+			continue
+		}
+
+		// idが指定されたリクエストには結果を返す
+		if request.Id != nil {
+			sub.writeJSON(&bitflyer.JsonRPC2{Version: "2.0", Result: true, Id: request.Id})
+		}
+
+		// 板のスナップショットは購読を開始した時点の板をすぐに送信する
+		if request.Method == "subscribe" {
+			if board, ok := s.boardSnapshot(params.Channel); ok {
+				sub.writeJSON(channelMessage(params.Channel, board))
+			}
+		}
+	}
+}
+
+func isPrivateChannel(channel string) bool {
+	return channel == bitflyer.ChannelChildOrderEvents || channel == bitflyer.ChannelParentOrderEvents
+}
+
+// channelを購読している全てのクライアントにメッセージを配信する
+func (s *Server) publish(channel string, message interface{}) {
+	s.mu.Lock()
+	subscribers := make([]*subscriber, 0, len(s.subscribers))
+	for sub := range s.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	s.mu.Unlock()
+
+	rpc := channelMessage(channel, message)
+	for _, sub := range subscribers {
+		if !sub.subscribed(channel) {
+			continue
+		}
+		if err := sub.writeJSON(rpc); err != nil {
+			log.Printf("action=publish err=%s", err.Error())
+		}
+	}
+}
+
+// Realtime APIのchannelMessageを生成する
+func channelMessage(channel string, message interface{}) *bitflyer.JsonRPC2 {
+	return &bitflyer.JsonRPC2{
+		Version: "2.0",
+		Method:  "channelMessage",
+		Params:  map[string]interface{}{"channel": channel, "message": message},
+	}
+}
@@ -0,0 +1,275 @@
+package bitflyer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// 特殊注文(親注文)の注文方法
+// https://lightning.bitflyer.com/docs?lang=ja#特殊注文
+const (
+	OrderMethodSimple = "SIMPLE" // 1つの注文
+	OrderMethodIFD    = "IFD"    // 1つ目の注文が約定したら2つ目の注文を発注する
+	OrderMethodOCO    = "OCO"    // 2つの注文を同時に発注し、一方が約定したらもう一方を取り消す
+	OrderMethodIFDOCO = "IFDOCO" // 1つ目の注文が約定したら2つ目と3つ目の注文をOCOで発注する
+)
+
+// 特殊注文の各注文の執行条件
+const (
+	ConditionLimit     = "LIMIT"      // 指値(priceが必須)
+	ConditionMarket    = "MARKET"     // 成行
+	ConditionStop      = "STOP"       // ストップ(trigger_priceに到達したら成行で発注)
+	ConditionStopLimit = "STOP_LIMIT" // ストップ・リミット(trigger_priceに到達したらpriceで指値を発注)
+	ConditionTrail     = "TRAIL"      // トレーリング・ストップ(最良価格からoffset分戻ったら成行で発注)
+)
+
+// 注文方法ごとの注文の数
+var parentOrderParameterCounts = map[string]int{
+	OrderMethodSimple: 1,
+	OrderMethodIFD:    2,
+	OrderMethodOCO:    2,
+	OrderMethodIFDOCO: 3,
+}
+
+// POST /v1/me/sendparentorder -Request Body Sample-
+// {
+//   "order_method": "IFDOCO",
+//   "minute_to_expire": 10000,
+//   "time_in_force": "GTC",
+//   "parameters": [{
+//     "product_code": "BTC_JPY",
+//     "condition_type": "LIMIT",
+//     "side": "BUY",
+//     "price": 30000,
+//     "size": 0.1
+//   },
+//   {
+//     "product_code": "BTC_JPY",
+//     "condition_type": "LIMIT",
+//     "side": "SELL",
+//     "price": 32000,
+//     "size": 0.1
+//   },
+//   {
+//     "product_code": "BTC_JPY",
+//     "condition_type": "STOP_LIMIT",
+//     "side": "SELL",
+//     "price": 28800,
+//     "trigger_price": 29000,
+//     "size": 0.1
+//   }]
+// }
+
+// 特殊注文を構成する1つの注文の型を定義
+type ParentOrderParameter struct {
+	ProductCode   string  `json:"product_code"`
+	ConditionType string  `json:"condition_type"`
+	Side          string  `json:"side"`
+	Size          float64 `json:"size"`
+	Price         float64 `json:"price,omitempty"`
+	TriggerPrice  float64 `json:"trigger_price,omitempty"`
+	Offset        float64 `json:"offset,omitempty"`
+}
+
+// 特殊注文の型を定義
+type ParentOrder struct {
+	OrderMethod     string                 `json:"order_method"`
+	MinuteToExpires int                    `json:"minute_to_expire,omitempty"`
+	TimeInForce     string                 `json:"time_in_force,omitempty"`
+	Parameters      []ParentOrderParameter `json:"parameters"`
+}
+
+// 注文方法と各注文の執行条件に必要な値が揃っているかを確認する
+func (order *ParentOrder) Validate() error {
+	count, ok := parentOrderParameterCounts[order.OrderMethod]
+	if !ok {
+		return fmt.Errorf("invalid order_method: %s", order.OrderMethod)
+	}
+	if len(order.Parameters) != count {
+		return fmt.Errorf("%s requires %d parameters, got %d", order.OrderMethod, count, len(order.Parameters))
+	}
+	for i, p := range order.Parameters {
+		if p.Side != "BUY" && p.Side != "SELL" {
+			return fmt.Errorf("parameters[%d]: invalid side: %s", i, p.Side)
+		}
+		if p.Size <= 0 {
+			return fmt.Errorf("parameters[%d]: size must be positive", i)
+		}
+		switch p.ConditionType {
+		case ConditionMarket:
+		case ConditionLimit:
+			if p.Price <= 0 {
+				return fmt.Errorf("parameters[%d]: LIMIT requires price", i)
+			}
+		case ConditionStop:
+			if p.TriggerPrice <= 0 {
+				return fmt.Errorf("parameters[%d]: STOP requires trigger_price", i)
+			}
+		case ConditionStopLimit:
+			if p.Price <= 0 || p.TriggerPrice <= 0 {
+				return fmt.Errorf("parameters[%d]: STOP_LIMIT requires price and trigger_price", i)
+			}
+		case ConditionTrail:
+			if p.Offset <= 0 {
+				return fmt.Errorf("parameters[%d]: TRAIL requires offset", i)
+			}
+		default:
+			return fmt.Errorf("parameters[%d]: invalid condition_type: %s", i, p.ConditionType)
+		}
+	}
+	return nil
+}
+
+// 新規注文と利益確定・損切りの注文を同時に発注するIFDOCO注文を生成する
+// entryPriceが0の場合は新規注文を成行で発注し、利益確定は指値、損切りはストップ(成行)で発注する
+func NewBracketOrder(productCode, side string, size, entryPrice, takeProfitPrice, stopLossPrice float64) *ParentOrder {
+	exitSide := "SELL"
+	if side == "SELL" {
+		exitSide = "BUY"
+	}
+	entry := ParentOrderParameter{ProductCode: productCode, ConditionType: ConditionMarket, Side: side, Size: size}
+	if entryPrice > 0 {
+		entry.ConditionType = ConditionLimit
+		entry.Price = entryPrice
+	}
+	return &ParentOrder{
+		OrderMethod: OrderMethodIFDOCO,
+		TimeInForce: "GTC",
+		Parameters: []ParentOrderParameter{
+			entry,
+			{ProductCode: productCode, ConditionType: ConditionLimit, Side: exitSide, Size: size, Price: takeProfitPrice},
+			{ProductCode: productCode, ConditionType: ConditionStop, Side: exitSide, Size: size, TriggerPrice: stopLossPrice},
+		},
+	}
+}
+
+// 特殊注文をした時に返されるデータのレスポンスの型を定義
+type ResponseSendParentOrder struct {
+	ParentOrderAcceptanceID string `json:"parent_order_acceptance_id"`
+}
+
+// 特殊注文の処理を定義
+// 複数の注文がまとめて発注されるため、二重注文を避けるために失敗しても再送は行わない
+func (api *APIClient) SendParentOrder(order *ParentOrder) (*ResponseSendParentOrder, error) {
+	return api.SendParentOrderContext(context.Background(), order)
+}
+
+func (api *APIClient) SendParentOrderContext(ctx context.Context, order *ParentOrder) (*ResponseSendParentOrder, error) {
+	if err := order.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := api.doRequest(ctx, "POST", "me/sendparentorder", map[string]string{}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ResponseSendParentOrder
+	if err := json.Unmarshal(resp, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GET /v1/me/getparentorders で返される特殊注文の一覧の型を定義
+type ParentOrderSummary struct {
+	ID                      int     `json:"id"`
+	ParentOrderID           string  `json:"parent_order_id"`
+	ProductCode             string  `json:"product_code"`
+	Side                    string  `json:"side"`
+	ParentOrderType         string  `json:"parent_order_type"`
+	Price                   float64 `json:"price"`
+	AveragePrice            float64 `json:"average_price"`
+	Size                    float64 `json:"size"`
+	ParentOrderState        string  `json:"parent_order_state"`
+	ExpireDate              string  `json:"expire_date"`
+	ParentOrderDate         string  `json:"parent_order_date"`
+	ParentOrderAcceptanceID string  `json:"parent_order_acceptance_id"`
+	OutstandingSize         float64 `json:"outstanding_size"`
+	CancelSize              float64 `json:"cancel_size"`
+	ExecutedSize            float64 `json:"executed_size"`
+	TotalCommission         float64 `json:"total_commission"`
+}
+
+// 特殊注文の一覧を取得する処理を定義
+// queryには product_code, count, before, after, parent_order_state を指定できる
+func (api *APIClient) ListParentOrders(query map[string]string) ([]ParentOrderSummary, error) {
+	return api.ListParentOrdersContext(context.Background(), query)
+}
+
+func (api *APIClient) ListParentOrdersContext(ctx context.Context, query map[string]string) ([]ParentOrderSummary, error) {
+	resp, err := api.doRequest(ctx, "GET", "me/getparentorders", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var orders []ParentOrderSummary
+	if err := json.Unmarshal(resp, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GET /v1/me/getparentorder で返される特殊注文の詳細の型を定義
+type ParentOrderDetail struct {
+	ID                      int                    `json:"id"`
+	ParentOrderID           string                 `json:"parent_order_id"`
+	OrderMethod             string                 `json:"order_method"`
+	ExpireDate              string                 `json:"expire_date"`
+	TimeInForce             string                 `json:"time_in_force"`
+	Parameters              []ParentOrderParameter `json:"parameters"`
+	ParentOrderAcceptanceID string                 `json:"parent_order_acceptance_id"`
+}
+
+// 特殊注文の詳細を取得する処理を定義(parentOrderIDかparentOrderAcceptanceIDのどちらかを指定する)
+func (api *APIClient) GetParentOrder(parentOrderID, parentOrderAcceptanceID string) (*ParentOrderDetail, error) {
+	return api.GetParentOrderContext(context.Background(), parentOrderID, parentOrderAcceptanceID)
+}
+
+func (api *APIClient) GetParentOrderContext(ctx context.Context, parentOrderID, parentOrderAcceptanceID string) (*ParentOrderDetail, error) {
+	query := map[string]string{}
+	switch {
+	case parentOrderID != "":
+		query["parent_order_id"] = parentOrderID
+	case parentOrderAcceptanceID != "":
+		query["parent_order_acceptance_id"] = parentOrderAcceptanceID
+	default:
+		return nil, fmt.Errorf("parent_order_id or parent_order_acceptance_id is required")
+	}
+	resp, err := api.doRequest(ctx, "GET", "me/getparentorder", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var detail ParentOrderDetail
+	if err := json.Unmarshal(resp, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// POST /v1/me/cancelparentorder のリクエストの型を定義
+type CancelParentOrder struct {
+	ProductCode             string `json:"product_code"`
+	ParentOrderID           string `json:"parent_order_id,omitempty"`
+	ParentOrderAcceptanceID string `json:"parent_order_acceptance_id,omitempty"`
+}
+
+// 特殊注文をキャンセルする処理を定義(成功した場合はレスポンスのbodyは空)
+func (api *APIClient) CancelParentOrder(cancel *CancelParentOrder) error {
+	return api.CancelParentOrderContext(context.Background(), cancel)
+}
+
+func (api *APIClient) CancelParentOrderContext(ctx context.Context, cancel *CancelParentOrder) error {
+	if cancel.ParentOrderID == "" && cancel.ParentOrderAcceptanceID == "" {
+		return fmt.Errorf("parent_order_id or parent_order_acceptance_id is required")
+	}
+	data, err := json.Marshal(cancel)
+	if err != nil {
+		return err
+	}
+	_, err = api.doRequest(ctx, "POST", "me/cancelparentorder", map[string]string{}, data)
+	return err
+}
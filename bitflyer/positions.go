@@ -0,0 +1,137 @@
+package bitflyer
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// GET /v1/me/getpositions -API Response Sample-
+// [
+//   {
+//     "product_code": "FX_BTC_JPY",
+//     "side": "BUY",
+//     "price": 36640,
+//     "size": 5.35,
+//     "commission": 0,
+//     "swap_point_accumulate": -35,
+//     "require_collateral": 120000,
+//     "open_date": "2015-11-03T10:04:45.011",
+//     "leverage": 3,
+//     "pnl": 965,
+//     "sfd": -0.5
+//   }
+// ]
+
+// 建玉(FX・先物のポジション)の型を定義
+type Position struct {
+	ProductCode         string  `json:"product_code"`
+	Side                string  `json:"side"`
+	Price               float64 `json:"price"`
+	Size                float64 `json:"size"`
+	Commission          float64 `json:"commission"`
+	SwapPointAccumulate float64 `json:"swap_point_accumulate"`
+	RequireCollateral   float64 `json:"require_collateral"`
+	OpenDate            string  `json:"open_date"`
+	Leverage            float64 `json:"leverage"`
+	Pnl                 float64 `json:"pnl"`
+	Sfd                 float64 `json:"sfd"`
+}
+
+// 建玉の一覧を取得する処理を定義(productCodeは FX_BTC_JPY などの証拠金取引の銘柄を指定する)
+func (api *APIClient) GetPositions(productCode string) ([]Position, error) {
+	return api.GetPositionsContext(context.Background(), productCode)
+}
+
+func (api *APIClient) GetPositionsContext(ctx context.Context, productCode string) ([]Position, error) {
+	resp, err := api.doRequest(ctx, "GET", "me/getpositions", map[string]string{"product_code": productCode}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var positions []Position
+	if err := json.Unmarshal(resp, &positions); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+// 建玉の数量の合計を計算する(買いを正、売りを負とする)
+func NetPositionSize(positions []Position) float64 {
+	var size float64
+	for _, p := range positions {
+		if p.Side == "SELL" {
+			size -= p.Size
+		} else {
+			size += p.Size
+		}
+	}
+	return size
+}
+
+// GET /v1/me/getcollateral -API Response Sample-
+// {
+//   "collateral": 100000,
+//   "open_position_pnl": -715,
+//   "require_collateral": 19857,
+//   "keep_rate": 5.000,
+//   "margin_call_amount": 0,
+//   "margin_call_due_date": null
+// }
+
+// 証拠金の状態の型を定義
+type Collateral struct {
+	Collateral        float64 `json:"collateral"`         // 預け入れた証拠金の評価額
+	OpenPositionPnl   float64 `json:"open_position_pnl"`  // 建玉の評価損益
+	RequireCollateral float64 `json:"require_collateral"` // 現在の必要証拠金
+	KeepRate          float64 `json:"keep_rate"`          // 証拠金維持率
+	MarginCallAmount  float64 `json:"margin_call_amount"`
+	MarginCallDueDate string  `json:"margin_call_due_date"`
+}
+
+// 新規の建玉に使用できる証拠金を計算するメソッド
+func (c *Collateral) Available() float64 {
+	return c.Collateral + c.OpenPositionPnl - c.RequireCollateral
+}
+
+// 証拠金の状態を取得する処理を定義
+func (api *APIClient) GetCollateral() (*Collateral, error) {
+	return api.GetCollateralContext(context.Background())
+}
+
+func (api *APIClient) GetCollateralContext(ctx context.Context) (*Collateral, error) {
+	resp, err := api.doRequest(ctx, "GET", "me/getcollateral", map[string]string{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var collateral Collateral
+	if err := json.Unmarshal(resp, &collateral); err != nil {
+		return nil, err
+	}
+	return &collateral, nil
+}
+
+// GET /v1/me/gettradingcommission -API Response Sample-
+// {
+//   "commission_rate": 0.001
+// }
+
+// 取引手数料の型を定義
+type TradingCommission struct {
+	CommissionRate float64 `json:"commission_rate"`
+}
+
+// 指定した銘柄の取引手数料率を取得する処理を定義
+func (api *APIClient) GetTradingCommission(productCode string) (*TradingCommission, error) {
+	return api.GetTradingCommissionContext(context.Background(), productCode)
+}
+
+func (api *APIClient) GetTradingCommissionContext(ctx context.Context, productCode string) (*TradingCommission, error) {
+	resp, err := api.doRequest(ctx, "GET", "me/gettradingcommission", map[string]string{"product_code": productCode}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var commission TradingCommission
+	if err := json.Unmarshal(resp, &commission); err != nil {
+		return nil, err
+	}
+	return &commission, nil
+}
@@ -0,0 +1,197 @@
+package bitflyer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Realtime APIのPrivate Channel(認証が必要なチャンネル)
+const (
+	ChannelChildOrderEvents  = "child_order_events"
+	ChannelParentOrderEvents = "parent_order_events"
+)
+
+var privateChannels = map[string]bool{
+	ChannelChildOrderEvents:  true,
+	ChannelParentOrderEvents: true,
+}
+
+// Realtime APIの認証に失敗した場合のエラー(APIキーが誤っている場合は再接続しても成功しないため、再接続は行わない)
+var ErrRealtimeAuth = errors.New("bitflyer: realtime api authentication failed")
+
+// 認証リクエストのid(購読リクエストにはidを付与しないため、このidのレスポンスを認証の結果とみなす)
+const authRequestID = 1
+
+// JSON-RPC 2.0 の auth メソッドのパラメータ
+// signatureは timestamp + nonce をAPIシークレットでHMAC-SHA256署名したもの
+type authParams struct {
+	APIKey    string `json:"api_key"`
+	Timestamp int64  `json:"timestamp"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+func (api *APIClient) newAuthParams() (*authParams, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	params := &authParams{
+		APIKey:    api.key,
+		Timestamp: time.Now().UnixMilli(),
+		Nonce:     hex.EncodeToString(nonce),
+	}
+	mac := hmac.New(sha256.New, []byte(api.secret))
+	mac.Write([]byte(fmt.Sprintf("%d%s", params.Timestamp, params.Nonce)))
+	params.Signature = hex.EncodeToString(mac.Sum(nil))
+	return params, nil
+}
+
+// 購読するチャンネルにPrivate Channelが含まれているか
+func containsPrivateChannel(channels []string) bool {
+	for _, channel := range channels {
+		if privateChannels[channel] {
+			return true
+		}
+	}
+	return false
+}
+
+// auth メソッドのリクエストを生成する(結果はid=authRequestIDのレスポンスとして受信する)
+func (api *APIClient) newAuthRequest() (*JsonRPC2, error) {
+	params, err := api.newAuthParams()
+	if err != nil {
+		return nil, err
+	}
+	id := authRequestID
+	return &JsonRPC2{Version: "2.0", Method: "auth", Params: params, Id: &id}, nil
+}
+
+// auth メソッドのレスポンスから認証の結果を判定する
+func authResult(message *channelMessage) error {
+	if message.Error != nil {
+		return fmt.Errorf("%w: code=%d message=%s", ErrRealtimeAuth, message.Error.Code, message.Error.Message)
+	}
+	var ok bool
+	if err := json.Unmarshal(message.Result, &ok); err != nil || !ok {
+		return ErrRealtimeAuth
+	}
+	return nil
+}
+
+// child_order_events -Message Sample-
+// [
+//   {
+//     "product_code": "BTC_JPY",
+//     "child_order_id": "JOR20150101-070921-054957",
+//     "child_order_acceptance_id": "JRF20150101-070921-054957",
+//     "event_date": "2015-01-01T07:09:21.9130958Z",
+//     "event_type": "EXECUTION",
+//     "exec_id": 39287,
+//     "side": "BUY",
+//     "price": 30000,
+//     "size": 0.1,
+//     "commission": 0,
+//     "sfd": 0,
+//     "outstanding_size": 0
+//   }
+// ]
+
+// 注文イベントの種類
+const (
+	EventOrder        = "ORDER"         // 注文を受け付けた
+	EventOrderFailed  = "ORDER_FAILED"  // 注文が失敗した
+	EventCancel       = "CANCEL"        // 注文を取り消した
+	EventCancelFailed = "CANCEL_FAILED" // 取り消しに失敗した
+	EventExecution    = "EXECUTION"     // 約定した
+	EventExpire       = "EXPIRE"        // 期限切れになった
+	EventTrigger      = "TRIGGER"       // 特殊注文の条件を満たして子注文を発注した
+	EventComplete     = "COMPLETE"      // 特殊注文の全ての注文が完了した
+)
+
+// 注文のイベントの型を定義
+type ChildOrderEvent struct {
+	ProductCode            string  `json:"product_code"`
+	ChildOrderID           string  `json:"child_order_id"`
+	ChildOrderAcceptanceID string  `json:"child_order_acceptance_id"`
+	EventDate              string  `json:"event_date"`
+	EventType              string  `json:"event_type"`
+	ChildOrderType         string  `json:"child_order_type,omitempty"` // ORDER
+	ExpireDate             string  `json:"expire_date,omitempty"`      // ORDER
+	Reason                 string  `json:"reason,omitempty"`           // ORDER_FAILED
+	ExecID                 int     `json:"exec_id,omitempty"`          // EXECUTION
+	Side                   string  `json:"side,omitempty"`             // ORDER, EXECUTION
+	Price                  float64 `json:"price,omitempty"`            // ORDER, EXECUTION
+	Size                   float64 `json:"size,omitempty"`             // ORDER, EXECUTION
+	Commission             float64 `json:"commission,omitempty"`       // EXECUTION
+	Sfd                    float64 `json:"sfd,omitempty"`              // EXECUTION
+	OutstandingSize        float64 `json:"outstanding_size,omitempty"` // EXECUTION
+}
+
+// 注文が終了した(これ以上イベントが発生しない)イベントかどうか
+func (e *ChildOrderEvent) IsFinal() bool {
+	switch e.EventType {
+	case EventOrderFailed, EventCancel, EventExpire:
+		return true
+	case EventExecution:
+		return e.OutstandingSize <= 0
+	}
+	return false
+}
+
+// 特殊注文のイベントの型を定義
+type ParentOrderEvent struct {
+	ProductCode             string  `json:"product_code"`
+	ParentOrderID           string  `json:"parent_order_id"`
+	ParentOrderAcceptanceID string  `json:"parent_order_acceptance_id"`
+	EventDate               string  `json:"event_date"`
+	EventType               string  `json:"event_type"`
+	ParentOrderType         string  `json:"parent_order_type,omitempty"`         // ORDER
+	Reason                  string  `json:"reason,omitempty"`                    // ORDER_FAILED
+	ChildOrderType          string  `json:"child_order_type,omitempty"`          // TRIGGER
+	ParameterIndex          int     `json:"parameter_index,omitempty"`           // TRIGGER, COMPLETE
+	ChildOrderAcceptanceID  string  `json:"child_order_acceptance_id,omitempty"` // TRIGGER
+	Side                    string  `json:"side,omitempty"`                      // TRIGGER
+	Price                   float64 `json:"price,omitempty"`                     // TRIGGER
+	Size                    float64 `json:"size,omitempty"`                      // TRIGGER
+	ExpireDate              string  `json:"expire_date,omitempty"`               // ORDER, TRIGGER
+}
+
+// Streamで自分の注文のイベントを購読し、chに送信する
+func (s *Stream) SubscribeChildOrderEvents(ch chan<- []ChildOrderEvent) *Subscription {
+	return s.Subscribe([]string{ChannelChildOrderEvents}, nil, sendTo("SubscribeChildOrderEvents", ch))
+}
+
+// Streamで自分の特殊注文のイベントを購読し、chに送信する
+func (s *Stream) SubscribeParentOrderEvents(ch chan<- []ParentOrderEvent) *Subscription {
+	return s.Subscribe([]string{ChannelParentOrderEvents}, nil, sendTo("SubscribeParentOrderEvents", ch))
+}
+
+// Realtime APIで自分の注文と特殊注文のイベントを購読する処理を定義
+// childChとparentChのうちnilを指定したチャンネルは購読しない
+// 接続が切れた場合は再接続を繰り返すため、認証に失敗しない限りこの関数は終了しない(goroutineで実行すること)
+func (api *APIClient) GetRealTimeOrderEvents(childCh chan<- []ChildOrderEvent, parentCh chan<- []ParentOrderEvent) error {
+	return api.GetRealTimeOrderEventsContext(context.Background(), childCh, parentCh)
+}
+
+// ctxがキャンセルされた場合は接続を閉じてctx.Err()を、認証に失敗した場合はErrRealtimeAuthを返す
+func (api *APIClient) GetRealTimeOrderEventsContext(ctx context.Context, childCh chan<- []ChildOrderEvent, parentCh chan<- []ParentOrderEvent) error {
+	if childCh == nil && parentCh == nil {
+		return errors.New("childCh or parentCh is required")
+	}
+	stream := api.NewStream()
+	if childCh != nil {
+		stream.SubscribeChildOrderEvents(childCh)
+	}
+	if parentCh != nil {
+		stream.SubscribeParentOrderEvents(parentCh)
+	}
+	return stream.Run(ctx)
+}
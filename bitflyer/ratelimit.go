@@ -0,0 +1,182 @@
+package bitflyer
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bitFlyerのAPIの呼出回数の上限を定義
+// https://lightning.bitflyer.com/docs?lang=ja#api-制限
+type RateLimits struct {
+	Public  int           // 同一IPアドレスからの呼出回数の上限(Private APIを含む全てのリクエストが対象)
+	Private int           // 同一APIキーでのPrivate APIの呼出回数の上限
+	Order   int           // 注文・取消系のPrivate APIの呼出回数の上限
+	Period  time.Duration // 上限の対象となる期間
+}
+
+var DefaultRateLimits = RateLimits{
+	Public:  500,
+	Private: 500,
+	Order:   300,
+	Period:  5 * time.Minute,
+}
+
+// 注文・取消系のエンドポイント(Order の上限の対象)
+var orderEndpoints = map[string]bool{
+	"me/sendchildorder":       true,
+	"me/cancelchildorder":     true,
+	"me/cancelallchildorders": true,
+	"me/sendparentorder":      true,
+	"me/cancelparentorder":    true,
+}
+
+// 呼出回数の上限を変更するオプション(0以下を指定した上限は無効になる)
+func WithRateLimits(limits RateLimits) Option {
+	return func(api *APIClient) {
+		api.publicLimiter = newRateLimiter(limits.Public, limits.Period)
+		api.privateLimiter = newRateLimiter(limits.Private, limits.Period)
+		api.orderLimiter = newRateLimiter(limits.Order, limits.Period)
+	}
+}
+
+// トークンバケット方式のレートリミッターを定義
+// 期間内の上限回数をバケットの容量とし、トークンは期間をかけて一定の速度で補充される
+type rateLimiter struct {
+	mu           sync.Mutex
+	capacity     float64
+	tokens       float64
+	refillRate   float64 // 1秒あたりに補充されるトークン数
+	last         time.Time
+	blockedUntil time.Time // レスポンスヘッダーで上限に達したと通知された場合の解除時刻
+}
+
+// limitが0以下の場合は制限を行わないためnilを返す
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	if limit <= 0 || period <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		capacity:   float64(limit),
+		tokens:     float64(limit),
+		refillRate: float64(limit) / period.Seconds(),
+		last:       time.Now(),
+	}
+}
+
+// トークンを1つ取得できるまで待つ(ctxがキャンセルされた場合はctx.Err()を返す)
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.refill(now)
+
+		var wait time.Duration
+		if now.Before(l.blockedUntil) {
+			wait = l.blockedUntil.Sub(now)
+		} else if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		} else {
+			wait = time.Duration((1 - l.tokens) / l.refillRate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Waitで取得したトークンを1つ戻す(リクエストを送信しなかった場合に使用する)
+func (l *rateLimiter) refund() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.tokens = math.Min(l.capacity, l.tokens+1)
+}
+
+// 全てのレートリミッターからトークンを1つずつ取得できるまで待つ
+// 途中でctxがキャンセルされた場合は、取得済みのトークンを戻してctx.Err()を返す
+func waitAll(ctx context.Context, limiters []*rateLimiter) error {
+	for i, limiter := range limiters {
+		if err := limiter.Wait(ctx); err != nil {
+			for _, acquired := range limiters[:i] {
+				acquired.refund()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens = math.Min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.refillRate)
+	l.last = now
+}
+
+// レスポンスヘッダーで通知された残り回数をトークン数に反映する
+// 残り回数が0の場合や429が返された場合は、リセット時刻までリクエストを止める
+func (l *rateLimiter) observe(resp *http.Response) {
+	if l == nil {
+		return
+	}
+	remaining, errRemaining := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, errReset := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	period, errPeriod := strconv.Atoi(resp.Header.Get("X-RateLimit-Period"))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+
+	if errRemaining == nil {
+		l.tokens = math.Min(l.tokens, float64(remaining))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || (errRemaining == nil && remaining <= 0) {
+		switch {
+		case errReset == nil:
+			l.blockedUntil = time.Unix(reset, 0)
+		case errPeriod == nil:
+			l.blockedUntil = time.Now().Add(time.Duration(period) * time.Second)
+		default:
+			l.blockedUntil = time.Now().Add(time.Duration(l.capacity/l.refillRate) * time.Second)
+		}
+		l.tokens = 0
+	}
+}
+
+// エンドポイントに応じて適用するレートリミッターを返す
+func (api *APIClient) rateLimiters(urlPath string) []*rateLimiter {
+	limiters := []*rateLimiter{api.publicLimiter}
+	if strings.HasPrefix(urlPath, "me/") {
+		limiters = append(limiters, api.privateLimiter)
+	}
+	if orderEndpoints[urlPath] {
+		limiters = append(limiters, api.orderLimiter)
+	}
+	return limiters
+}
+
+// レスポンスヘッダーの残り回数は、Private APIの場合はAPIキー、それ以外はIPアドレス単位の値として扱う
+func (api *APIClient) observeRateLimit(urlPath string, resp *http.Response) {
+	if strings.HasPrefix(urlPath, "me/") {
+		api.privateLimiter.observe(resp)
+		return
+	}
+	api.publicLimiter.observe(resp)
+}
@@ -0,0 +1,152 @@
+package bitflyer
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 後のレートリミッターで待っている間にキャンセルされた場合は、先に取得したトークンを戻す
+func TestWaitAllRefundsOnCancel(t *testing.T) {
+	public := newRateLimiter(10, time.Hour)
+	private := newRateLimiter(1, time.Hour)
+	if err := private.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := waitAll(ctx, []*rateLimiter{public, private}); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if public.tokens < 10-1e-6 {
+		t.Errorf("public tokens = %f, want 10 (refunded)", public.tokens)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	if err := (*rateLimiter)(nil).Wait(context.Background()); err != nil {
+		t.Errorf("nil limiter Wait = %v, want nil", err)
+	}
+	if newRateLimiter(0, time.Minute) != nil || newRateLimiter(10, 0) != nil {
+		t.Errorf("newRateLimiter with limit 0 or period 0 returned a limiter")
+	}
+
+	// 容量分は待たずに取得でき、それを超えると補充されるまで待つ
+	l := newRateLimiter(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := l.Wait(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Wait %d = %v, want nil", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait over capacity = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 期間の1/3が経過すると1つ補充される
+	l.mu.Lock()
+	l.last = l.last.Add(-20 * time.Minute)
+	l.mu.Unlock()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait after refill = %v, want nil", err)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"no time elapsed", 2, 0, 2},
+		{"partial refill", 0, 30 * time.Second, 5},
+		{"capped at capacity", 8, time.Hour, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(10, time.Minute)
+			now := time.Now()
+			l.tokens = tt.tokens
+			l.last = now.Add(-tt.elapsed)
+			l.refill(now)
+			if math.Abs(l.tokens-tt.want) > 1e-9 {
+				t.Errorf("tokens = %f, want %f", l.tokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	reset := time.Now().Add(90 * time.Second).Truncate(time.Second)
+	tests := []struct {
+		name        string
+		status      int
+		header      map[string]string
+		wantTokens  float64
+		wantBlocked time.Duration // 現在時刻からの解除までの時間の目安(0の場合は止めない)
+	}{
+		{"no headers", http.StatusOK, nil, 10, 0},
+		{"remaining lowers tokens", http.StatusOK, map[string]string{"X-RateLimit-Remaining": "4"}, 4, 0},
+		{"remaining does not raise tokens", http.StatusOK, map[string]string{"X-RateLimit-Remaining": "400"}, 10, 0},
+		{"remaining 0 blocks until reset", http.StatusOK,
+			map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)}, 0, 90 * time.Second},
+		{"429 blocks for period", http.StatusTooManyRequests, map[string]string{"X-RateLimit-Period": "30"}, 0, 30 * time.Second},
+		{"429 without headers blocks for the whole period", http.StatusTooManyRequests, nil, 0, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(10, time.Minute)
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for key, value := range tt.header {
+				resp.Header.Set(key, value)
+			}
+			l.observe(resp)
+			if math.Abs(l.tokens-tt.wantTokens) > 0.01 {
+				t.Errorf("tokens = %f, want %f", l.tokens, tt.wantTokens)
+			}
+			blocked := time.Until(l.blockedUntil)
+			if tt.wantBlocked == 0 {
+				if blocked > 0 {
+					t.Errorf("blocked for %s, want not blocked", blocked)
+				}
+			} else if blocked < tt.wantBlocked-2*time.Second || blocked > tt.wantBlocked {
+				t.Errorf("blocked for %s, want about %s", blocked, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestRateLimiters(t *testing.T) {
+	api := New("key", "secret")
+	tests := []struct {
+		urlPath string
+		want    []*rateLimiter
+	}{
+		{"ticker", []*rateLimiter{api.publicLimiter}},
+		{"me/getbalance", []*rateLimiter{api.publicLimiter, api.privateLimiter}},
+		{"me/sendchildorder", []*rateLimiter{api.publicLimiter, api.privateLimiter, api.orderLimiter}},
+		{"me/cancelallchildorders", []*rateLimiter{api.publicLimiter, api.privateLimiter, api.orderLimiter}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.urlPath, func(t *testing.T) {
+			got := api.rateLimiters(tt.urlPath)
+			if len(got) != len(tt.want) {
+				t.Fatalf("limiters = %d, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("limiters[%d] differs", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package bitflyer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Realtime APIの接続状態を定義
+type ConnectionState int
+
+const (
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateDisconnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return "unknown"
+}
+
+// 再接続までの待ち時間を変更するオプション(失敗するたびにminから2倍ずつ増やし、maxで打ち止め)
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(api *APIClient) {
+		if min > 0 && max >= min {
+			api.reconnectMin = min
+			api.reconnectMax = max
+		}
+	}
+}
+
+// 死活監視の設定を変更するオプション
+// pingIntervalごとにpingを送信し、readTimeoutの間メッセージもpongも受信しなかった場合は切断して再接続する
+func WithKeepAlive(pingInterval, readTimeout time.Duration) Option {
+	return func(api *APIClient) {
+		if pingInterval > 0 && readTimeout > pingInterval {
+			api.pingInterval = pingInterval
+			api.readTimeout = readTimeout
+		}
+	}
+}
+
+// 接続状態が変化した時に呼び出される関数を設定するオプション(切断時はerrに原因が入る)
+func WithConnectionStateHandler(fn func(state ConnectionState, err error)) Option {
+	return func(api *APIClient) {
+		api.onStateChange = fn
+	}
+}
+
+// channelMessageとして受信するメッセージの形式(idを付与したリクエストへのレスポンスもこの型で受信する)
+type channelMessage struct {
+	Version string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  struct {
+		Channel string          `json:"channel"`
+		Message json.RawMessage `json:"message"`
+	} `json:"params"`
+	Id     *int            `json:"id,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// 1つの接続で複数のチャンネルを購読するRealtime APIのセッションを定義
+// Runの実行中もSubscribe/Unsubscribeでチャンネルを追加・削除でき、受信したメッセージは購読ごとのハンドラーに振り分ける
+// 接続の失敗や切断を検知した場合は指数バックオフで待ってから再接続し、購読中の全てのチャンネルを購読し直す
+type Stream struct {
+	api *APIClient
+
+	mu            sync.Mutex
+	subscriptions map[string]map[*Subscription]bool // チャンネル => 購読
+	conn          *websocket.Conn                   // 接続中の場合のみ設定される
+	authenticated bool                              // 接続中のセッションで認証済みかどうか
+	authPending   bool                              // 認証の結果を待っているかどうか
+	running       bool
+
+	writeMu sync.Mutex // gorilla/websocketは同時に書き込みできないため排他制御を行う
+}
+
+// Streamで購読しているチャンネルと、メッセージを受け取るハンドラーを定義
+// ハンドラーは購読ごとのgoroutineで呼び出し、受信処理のgoroutineからはキューに追加するだけにする
+type Subscription struct {
+	stream    *Stream
+	channels  []string
+	onConnect func()
+	handle    func(ctx context.Context, channel string, message json.RawMessage)
+
+	mu       sync.Mutex
+	queue    chan func() // ハンドラーに渡すメッセージと、onConnectの呼び出し
+	dropped  int         // キューが溢れて破棄したメッセージの数(0より大きい間はonConnectを呼び出し直してから再開する)
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// 購読ごとのキューに溜めておけるメッセージの数
+const subscriptionQueueSize = 1024
+
+// Realtime APIのセッションを生成するコンストラクタ(Runを呼び出すまで接続しない)
+func (api *APIClient) NewStream() *Stream {
+	return &Stream{api: api, subscriptions: map[string]map[*Subscription]bool{}}
+}
+
+// channelsを購読し、受信したメッセージをhandleに渡す
+// onConnectが指定されている場合は、購読を開始するたびに(再接続時も含めて)呼び出す
+// handleとonConnectは購読ごとのgoroutineで順番に呼び出すため、処理が滞っても他の購読や受信は止まらない
+// 処理が追いつかずにキューが溢れた場合はメッセージを破棄し、切断した場合と同じくonConnectを呼び出してから受信を再開する
+func (s *Stream) Subscribe(channels []string, onConnect func(), handle func(ctx context.Context, channel string, message json.RawMessage)) *Subscription {
+	sub := &Subscription{
+		stream:    s,
+		channels:  channels,
+		onConnect: onConnect,
+		handle:    handle,
+		queue:     make(chan func(), subscriptionQueueSize),
+		done:      make(chan struct{}),
+	}
+	go sub.run()
+
+	s.mu.Lock()
+	var added []string
+	for _, channel := range channels {
+		if s.subscriptions[channel] == nil {
+			s.subscriptions[channel] = map[*Subscription]bool{}
+			added = append(added, channel)
+		}
+		s.subscriptions[channel][sub] = true
+	}
+	conn := s.conn
+	s.mu.Unlock()
+
+	// 接続中の場合は新しいチャンネルだけを購読する(未接続の場合は接続時にまとめて購読する)
+	if conn != nil {
+		if err := s.subscribeChannels(conn, added); err != nil {
+			log.Printf("action=Subscribe err=%s", err.Error())
+		}
+		sub.connected()
+	}
+	return sub
+}
+
+// キューに追加された処理を順番に実行する(Unsubscribeされるまで終了しない)
+func (sub *Subscription) run() {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case fn := <-sub.queue:
+			fn()
+		}
+	}
+}
+
+// 購読を開始したことをonConnectに通知する
+func (sub *Subscription) connected() {
+	if sub.onConnect == nil {
+		return
+	}
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if len(sub.queue) < cap(sub.queue) {
+		sub.queue <- sub.onConnect
+		return
+	}
+	// キューが空くまでメッセージを破棄し、空いた時点でonConnectを呼び出す
+	sub.dropped++
+}
+
+// 受信したメッセージをキューに追加する(受信処理を止めないよう、キューが溢れた場合は待たずに破棄する)
+func (sub *Subscription) deliver(ctx context.Context, channel string, message json.RawMessage) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	// 取りこぼした後はonConnectとメッセージの両方を追加できるようになるまで破棄し続ける
+	// キューに追加するのはmuをロックしている場合のみのため、空きがあれば待たずに追加できる
+	need := 1
+	if sub.dropped > 0 && sub.onConnect != nil {
+		need = 2
+	}
+	if len(sub.queue)+need > cap(sub.queue) {
+		if sub.dropped == 0 {
+			log.Printf("action=realtime status=drop channels=%v err=subscriber queue is full", sub.channels)
+		}
+		sub.dropped++
+		return
+	}
+	if sub.dropped > 0 {
+		log.Printf("action=realtime status=resume channels=%v dropped=%d", sub.channels, sub.dropped)
+		sub.dropped = 0
+		if sub.onConnect != nil {
+			sub.queue <- sub.onConnect
+		}
+	}
+	sub.queue <- func() { sub.handle(ctx, channel, message) }
+}
+
+// 購読を解除する(他の購読がないチャンネルはRealtime APIの購読も解除する)
+func (sub *Subscription) Unsubscribe() {
+	sub.stopOnce.Do(func() { close(sub.done) })
+	s := sub.stream
+	s.mu.Lock()
+	var removed []string
+	for _, channel := range sub.channels {
+		subs, ok := s.subscriptions[channel]
+		if !ok || !subs[sub] {
+			continue
+		}
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(s.subscriptions, channel)
+			removed = append(removed, channel)
+		}
+	}
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+	for _, channel := range removed {
+		if err := s.writeJSON(conn, &JsonRPC2{Version: "2.0", Method: "unsubscribe", Params: &SubscribeParams{channel}}); err != nil {
+			log.Printf("action=Unsubscribe err=%s", err.Error())
+		}
+	}
+}
+
+// 購読中のチャンネルを返す
+func (s *Stream) Channels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := make([]string, 0, len(s.subscriptions))
+	for channel := range s.subscriptions {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (s *Stream) writeJSON(conn *websocket.Conn, v interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return conn.WriteJSON(v)
+}
+
+// チャンネルを購読する(Private Channelは認証済みの場合のみ購読し、未認証の場合は認証を要求する)
+func (s *Stream) subscribeChannels(conn *websocket.Conn, channels []string) error {
+	s.mu.Lock()
+	authenticated := s.authenticated
+	requestAuth := false
+	if !authenticated && !s.authPending && containsPrivateChannel(channels) {
+		s.authPending = true
+		requestAuth = true
+	}
+	s.mu.Unlock()
+
+	if requestAuth {
+		request, err := s.api.newAuthRequest()
+		if err != nil {
+			return err
+		}
+		if err := s.writeJSON(conn, request); err != nil {
+			return err
+		}
+	}
+	for _, channel := range channels {
+		if privateChannels[channel] && !authenticated {
+			// 認証に成功した時点で購読する
+			continue
+		}
+		if err := s.writeJSON(conn, &JsonRPC2{Version: "2.0", Method: "subscribe", Params: &SubscribeParams{channel}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 認証の結果を反映し、成功した場合は購読中のPrivate Channelを購読する
+func (s *Stream) handleAuthResult(conn *websocket.Conn, message *channelMessage) error {
+	if err := authResult(message); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.authenticated = true
+	s.authPending = false
+	var channels []string
+	for channel := range s.subscriptions {
+		if privateChannels[channel] {
+			channels = append(channels, channel)
+		}
+	}
+	s.mu.Unlock()
+	return s.subscribeChannels(conn, channels)
+}
+
+// 受信したメッセージをチャンネルを購読している全ての購読のキューに追加する
+func (s *Stream) dispatch(ctx context.Context, channel string, message json.RawMessage) {
+	s.mu.Lock()
+	subs := make([]*Subscription, 0, len(s.subscriptions[channel]))
+	for sub := range s.subscriptions[channel] {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.deliver(ctx, channel, message)
+	}
+}
+
+// Realtime APIに接続し、ctxがキャンセルされるまで再接続を繰り返す処理を定義
+// ctxがキャンセルされた場合は接続を閉じてctx.Err()を、認証に失敗した場合はErrRealtimeAuthを返す
+func (s *Stream) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return errors.New("stream is already running")
+	}
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	api := s.api
+	backoff := api.reconnectMin
+	for {
+		api.setConnectionState(StateConnecting, nil)
+		received, err := s.runConnection(ctx)
+		if ctx.Err() != nil {
+			api.setConnectionState(StateDisconnected, ctx.Err())
+			return ctx.Err()
+		}
+		api.setConnectionState(StateDisconnected, err)
+		if errors.Is(err, ErrRealtimeAuth) {
+			return err
+		}
+
+		// 一度でもメッセージを受信できた場合は接続できていたとみなし、待ち時間を初期値に戻す
+		if received {
+			backoff = api.reconnectMin
+		}
+
+		// 複数の接続が同時に再接続しないよう、待ち時間を最大20%ずらす
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+		log.Printf("action=realtime status=reconnecting wait=%s", wait)
+		select {
+		case <-ctx.Done():
+			api.setConnectionState(StateDisconnected, ctx.Err())
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > api.reconnectMax {
+			backoff = api.reconnectMax
+		}
+	}
+}
+
+// 1回分の接続処理を定義(切断されるまで戻らない)
+func (s *Stream) runConnection(ctx context.Context) (received bool, err error) {
+	api := s.api
+	log.Printf("connecting to %s", api.webSocketURL)
+	c, _, err := websocket.DefaultDialer.DialContext(ctx, api.webSocketURL, nil)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	// pongを受信するたびに読み込みの期限を延長する
+	c.SetReadDeadline(time.Now().Add(api.readTimeout))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(api.readTimeout))
+	})
+
+	// 接続を公開してから購読中のチャンネルを取得し、以降に追加された購読はSubscribeで購読させる
+	s.mu.Lock()
+	s.conn = c
+	s.authenticated = false
+	s.authPending = false
+	var channels []string
+	var subs []*Subscription
+	seen := map[*Subscription]bool{}
+	for channel, channelSubs := range s.subscriptions {
+		channels = append(channels, channel)
+		for sub := range channelSubs {
+			if !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
+			}
+		}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+
+	sort.Strings(channels)
+	if err := s.subscribeChannels(c, channels); err != nil {
+		return false, err
+	}
+	api.setConnectionState(StateConnected, nil)
+	for _, sub := range subs {
+		sub.connected()
+	}
+
+	// 定期的にpingを送信(WriteControlは他の書き込みと並行して呼び出せる)
+	// ctxがキャンセルされた場合は接続を閉じて読み込み待ちを中断させる
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(api.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				c.Close()
+				return
+			case <-ticker.C:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(api.pingInterval)); err != nil {
+					log.Printf("action=realtime ping err=%s", err.Error())
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var message channelMessage
+		if err := c.ReadJSON(&message); err != nil {
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return received, errors.New("no message received within read timeout")
+			}
+			return received, err
+		}
+		c.SetReadDeadline(time.Now().Add(api.readTimeout))
+
+		if message.Id != nil && *message.Id == authRequestID {
+			if err := s.handleAuthResult(c, &message); err != nil {
+				return received, err
+			}
+			continue
+		}
+		if message.Method != "channelMessage" {
+			continue
+		}
+		received = true
+		s.dispatch(ctx, message.Params.Channel, message.Params.Message)
+	}
+}
+
+// 1つのチャンネルのメッセージをJSONとして読み込み、chに送信するハンドラーを生成する
+// chが受信されない間はctxがキャンセルされるまで待つ(待っている間に届いたメッセージは購読のキューに溜まる)
+func sendTo[T any](action string, ch chan<- T) func(ctx context.Context, channel string, message json.RawMessage) {
+	return func(ctx context.Context, channel string, message json.RawMessage) {
+		var v T
+		if err := json.Unmarshal(message, &v); err != nil {
+			log.Printf("action=%s err=%s", action, err.Error())
+			return
+		}
+		select {
+		case ch <- v:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// 接続状態の変化をログに出力し、設定されている場合はハンドラーを呼び出す
+func (api *APIClient) setConnectionState(state ConnectionState, err error) {
+	if err != nil {
+		log.Printf("action=realtime state=%s err=%s", state, err.Error())
+	} else {
+		log.Printf("action=realtime state=%s", state)
+	}
+	if api.onStateChange != nil {
+		api.onStateChange(state, err)
+	}
+}
@@ -0,0 +1,126 @@
+package bitflyer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 注文(SendOrder)が失敗した場合の再送の設定を定義
+// 送信に失敗した場合は、再送する前に注文の一覧(ListOrder)から失敗した送信で受け付けられた注文を探し、見つかった場合は再送しない
+// bitFlyerの子注文にはクライアント側で指定できるIDがないため、同じ銘柄・売買方向・注文の種類・数量(指値の場合は価格)で、
+// 送信を開始した時刻のClockSkew前以降に受け付けられた注文を送信した注文とみなす
+// そのため同じAPIキーで別のプロセスや手動で同じ内容の注文をその期間に出した場合は、その注文と取り違えて再送しない
+// また、サーバーとの時刻のずれがClockSkewより大きい場合や、受け付けから注文の一覧に反映されるまでが待ち時間より長い場合は、
+// 受け付けられた注文を見つけられずに再送するため二重注文になる
+// 送信に成功した場合は注文の一覧を取得しないため、失敗しない限りAPIの呼出回数は増えない
+type RetryPolicy struct {
+	MaxAttempts    int           // 最初の送信を含めた最大の送信回数(1以下の場合は再送しない)
+	InitialBackoff time.Duration // 1回目の再送までの待ち時間(以降は2倍ずつ増やす)
+	MaxBackoff     time.Duration // 再送までの待ち時間の上限
+	ClockSkew      time.Duration // 注文の受付時刻とクライアントの時刻のずれとして許容する時間
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+	ClockSkew:      5 * time.Second,
+}
+
+// 注文の再送の設定を変更するオプション
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(api *APIClient) {
+		if policy.InitialBackoff <= 0 {
+			policy.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+		}
+		if policy.MaxBackoff < policy.InitialBackoff {
+			policy.MaxBackoff = policy.InitialBackoff
+		}
+		if policy.ClockSkew <= 0 {
+			policy.ClockSkew = DefaultRetryPolicy.ClockSkew
+		}
+		api.retryPolicy = policy
+	}
+}
+
+// 再送してよいエラーかどうかを判定
+// ネットワークエラー、5xx、リクエスト数の上限超過、bitFlyerが再送を求めるエラー(-208)のみ再送する
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.HTTPStatus >= http.StatusInternalServerError ||
+			IsRateLimited(err) ||
+			apiErr.Status == StatusOrderNotAccepted
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// 送信した注文を探す時に1回のListOrderで取得する注文の数
+const orderPageSize = 100
+
+// 送信した注文と同じ内容で、送信を開始した時刻以降に受け付けられた注文を探す
+// bitFlyerの子注文にはクライアント側で指定できるIDがないため、注文の内容と受付時刻で同じ注文かを判定する
+// 新しい順にbeforeでページを遡り、sentAtのClockSkew前より古い注文まで到達したら探すのをやめる
+// afterより大きいページングのidの注文のみを対象とし、前回の確認で取得した注文を取得し直さないようにする
+// 見つからなかった場合は、次の確認でafterに指定する取得済みの最も新しいidを返す
+func (api *APIClient) findSentOrder(ctx context.Context, order *Order, sentAt time.Time, after int) (*Order, int, error) {
+	// サーバーとの時刻のずれを考慮して、送信開始のClockSkew前以降に受け付けられた注文を対象とする
+	since := sentAt.Add(-api.retryPolicy.ClockSkew).UTC()
+	newest := after
+	before := 0
+	for {
+		query := map[string]string{
+			"product_code": order.ProductCode,
+			"count":        strconv.Itoa(orderPageSize),
+		}
+		if after > 0 {
+			query["after"] = strconv.Itoa(after)
+		}
+		if before > 0 {
+			query["before"] = strconv.Itoa(before)
+		}
+		orders, err := api.ListOrderContext(ctx, query)
+		if err != nil {
+			return nil, after, err
+		}
+
+		reached := len(orders) < orderPageSize
+		for i := range orders {
+			o := &orders[i]
+			if o.ID > newest {
+				newest = o.ID
+			}
+			if before == 0 || o.ID < before {
+				before = o.ID
+			}
+			orderDate, err := time.Parse("2006-01-02T15:04:05", o.ChildOrderDate)
+			if err != nil {
+				continue
+			}
+			if orderDate.Before(since) {
+				reached = true
+				continue
+			}
+			if o.Side == order.Side &&
+				o.ChildOrderType == order.ChildOrderType &&
+				o.Size == order.Size &&
+				(order.ChildOrderType == "MARKET" || o.Price == order.Price) {
+				return o, newest, nil
+			}
+		}
+		if reached || before <= 0 {
+			return nil, newest, nil
+		}
+	}
+}
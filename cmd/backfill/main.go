@@ -0,0 +1,56 @@
+// bitFlyerの約定履歴からCandleを復元し、保存済みのCandleに反映するコマンド
+//
+//	go run ./cmd/backfill -since 24h
+//	go run ./cmd/backfill -products BTC_JPY -since 1h -before 2512345678
+//	go run ./cmd/backfill -since 31d
+//
+// config.iniと同じディレクトリで実行し、configで指定された全ての時間足のテーブルに反映する
+// bitFlyerの約定履歴は直近の一定期間(31日程度)しか取得できないため、sinceはその範囲で指定する
+// sinceは/api/gaps/と同じく日(d)と週(w)の単位でも指定できる
+package main
+
+import (
+	"context"
+	"flag"
+	"gotrading/app/controllers"
+	"gotrading/bitflyer"
+	"gotrading/config"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	products := flag.String("products", strings.Join(config.Config.ProductCodes, ","), "comma separated product codes")
+	since := flag.String("since", "24h", "backfill executions newer than this duration (ex: 24h, 1d, 31d)")
+	before := flag.Int("before", 0, "start paging from executions older than this id (0 = latest)")
+	flag.Parse()
+	sinceDuration, err := config.ParseDuration(*since)
+	if err != nil || sinceDuration <= 0 {
+		log.Fatalf("action=backfill err=invalid since: %s", *since)
+	}
+
+	// Ctrl+C(SIGINT)またはSIGTERMを受信したら取得を中断する(取得済みの約定履歴は反映する)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	apiClient := bitflyer.New(config.Config.ApiKey, config.Config.ApiSecret, bitflyer.WithBaseURL(config.Config.BaseURL))
+
+	for _, productCode := range strings.Split(*products, ",") {
+		if !config.Config.HasProduct(productCode) {
+			log.Printf("action=backfill product_code=%s err=product_code is not configured", productCode)
+			continue
+		}
+		results, err := controllers.BackfillCandles(ctx, apiClient, productCode, controllers.ConfigDurations(), time.Now().UTC().Add(-sinceDuration), *before)
+		if err != nil {
+			log.Printf("action=backfill product_code=%s err=%s", productCode, err.Error())
+		}
+		for _, result := range results {
+			log.Printf("action=backfill product_code=%s duration=%s replaced=%d merged=%d",
+				productCode, result.Duration, result.Replaced, result.Merged)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Candleのテーブルの欠損を調べ、指定した方法で修復するコマンド
+//
+//	go run ./cmd/gaps -since 24h                  // 欠損を出力する
+//	go run ./cmd/gaps -since 24h -repair fill     // 直前の終値で埋める
+//	go run ./cmd/gaps -since 24h -repair backfill // 約定履歴から復元する
+//	go run ./cmd/gaps -since 1w                   // sinceは/api/gaps/と同じく日(d)と週(w)の単位でも指定できる
+//
+// config.iniと同じディレクトリで実行する
+package main
+
+import (
+	"context"
+	"flag"
+	"gotrading/app/controllers"
+	"gotrading/bitflyer"
+	"gotrading/config"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	products := flag.String("products", strings.Join(config.Config.ProductCodes, ","), "comma separated product codes")
+	since := flag.String("since", "24h", "scan candles newer than this duration (ex: 24h, 1d, 1w)")
+	repair := flag.String("repair", "", "repair method (fill or backfill, empty = report only)")
+	flag.Parse()
+	sinceDuration, err := config.ParseDuration(*since)
+	if err != nil || sinceDuration <= 0 {
+		log.Fatalf("action=gaps err=invalid since: %s", *since)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var productCodes []string
+	for _, productCode := range strings.Split(*products, ",") {
+		if !config.Config.HasProduct(productCode) {
+			log.Printf("action=gaps product_code=%s err=product_code is not configured", productCode)
+			continue
+		}
+		productCodes = append(productCodes, productCode)
+	}
+
+	report, err := controllers.ScanGaps(productCodes, controllers.ConfigDurations(), time.Now().UTC().Add(-sinceDuration))
+	if err != nil {
+		log.Fatalf("action=gaps err=%s", err.Error())
+	}
+	for _, gap := range report.Gaps {
+		log.Printf("action=gaps product_code=%s duration=%s from=%s to=%s missing=%d",
+			gap.ProductCode, gap.Duration, gap.From.Format(time.RFC3339), gap.To.Format(time.RFC3339), gap.Missing)
+	}
+	log.Printf("action=gaps gaps=%d missing=%d", len(report.Gaps), report.Missing)
+
+	if *repair == "" || len(report.Gaps) == 0 {
+		return
+	}
+	apiClient := bitflyer.New(config.Config.ApiKey, config.Config.ApiSecret, bitflyer.WithBaseURL(config.Config.BaseURL))
+	if err := controllers.RepairGaps(ctx, apiClient, report.Gaps, *repair); err != nil {
+		log.Fatalf("action=gaps err=%s", err.Error())
+	}
+}
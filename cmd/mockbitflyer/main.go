@@ -0,0 +1,59 @@
+// bitFlyer Lightning APIのモックサーバーを起動するコマンド
+//
+//	go run ./cmd/mockbitflyer -addr :9090 -products BTC_JPY,ETH_JPY
+//
+// config.iniの[bitflyer]に以下を設定するとアプリケーションがモックサーバーに接続する
+//
+//	base_url = http://localhost:9090/v1/
+//	ws_url = ws://localhost:9090/json-rpc
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"gotrading/bitflyer"
+	"gotrading/bitflyer/mockserver"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	products := flag.String("products", "BTC_JPY", "comma separated product codes")
+	interval := flag.Duration("interval", time.Second, "ticker update interval")
+	volatility := flag.Float64("volatility", 0.0005, "random walk volatility per tick")
+	jpy := flag.Float64("jpy", 1000000, "initial JPY balance")
+	script := flag.String("script", "", "JSON file of scripted tickers per product code ({\"BTC_JPY\": [{...}, ...]})")
+	commission := flag.Float64("commission", 0, "commission rate for spot products")
+	seed := flag.Int64("seed", 0, "random seed (0 = current time)")
+	flag.Parse()
+
+	config := mockserver.Config{
+		ProductCodes:   strings.Split(*products, ","),
+		Balances:       map[string]float64{"JPY": *jpy},
+		Interval:       *interval,
+		Volatility:     *volatility,
+		CommissionRate: *commission,
+		Seed:           *seed,
+	}
+	if *script != "" {
+		config.Script = loadScript(*script)
+	}
+
+	log.Fatal(mockserver.New(config).ListenAndServe(*addr))
+}
+
+// スクリプトファイルから銘柄ごとのTickerを読み込む
+func loadScript(path string) map[string][]bitflyer.Ticker {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("action=loadScript err=%s", err.Error())
+	}
+	var script map[string][]bitflyer.Ticker
+	if err := json.Unmarshal(data, &script); err != nil {
+		log.Fatalf("action=loadScript err=%s", err.Error())
+	}
+	return script
+}
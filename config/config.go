@@ -1,24 +1,53 @@
 package config
 
 import (
+	"gotrading/bitflyer"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/go-ini/ini.v1"
 )
 
+// 銘柄ごとの売買の設定を定義([product.BTC_JPY] のようなセクションで銘柄ごとに上書きできる)
+type ProductConfig struct {
+	ProductCode      string
+	TradeDuration    time.Duration
+	Strategy         string
+	UsePercent       float64
+	DataLimit        int
+	BackTest         bool
+	OptimizeInterval time.Duration
+}
+
 type ConfigList struct {
-	ApiKey      string
-	ApiSecret   string
-	LogFile     string
-	ProductCode string
-
-	TradeDuration time.Duration
-	Durations     map[string]time.Duration
-	DbName        string
-	SQLDriver     string
-	Port          int
+	ApiKey       string
+	ApiSecret    string
+	BaseURL      string
+	WebSocketURL string
+	LogFile      string
+	ProductCode  string   // 最初の銘柄(チャートなどで銘柄の指定がない場合に使用)
+	ProductCodes []string // データの取り込みと売買を行う銘柄
+	Products     map[string]ProductConfig
+	CandleSource string // Candleの生成に使用するデータ(executions: 約定履歴, ticker: Tickerの仲値、出来高は0)
+
+	// 銘柄ごとの設定を省略した場合の売買の設定
+	TradeDuration    time.Duration
+	Strategy         string
+	UsePercent       float64
+	DataLimit        int
+	BackTest         bool
+	OptimizeInterval time.Duration
+	Durations        map[string]time.Duration // Candleのテーブルを作成して取り込み時に保存する時間足
+	// 保存せず、Durationsの時間足のCandleを集計して生成する時間足(取り込み時の書き込みは増えない)
+	ResampleDurations map[string]time.Duration
+	DbName            string
+	SQLDriver         string
+	Port              int
+
+	// 作成途中のCandleをメモリに保持し、まとめてデータベースに書き込む間隔(時間足が切り替わった場合はすぐに書き込む)
+	CandleFlushInterval time.Duration
 }
 
 var Config ConfigList
@@ -30,21 +59,102 @@ func init() {
 		os.Exit(1)
 	}
 
-	durations := map[string]time.Duration{
-		"1s": time.Second,
-		"1m": time.Minute,
-		"1h": time.Hour,
+	// durations = 1s,1m,1h のように保存する時間足を、resample_durations に集計して生成する時間足を指定する
+	durations, err := parseDurations(cfg.Section("gotrading").Key("durations").MustString("1s,1m,1h"))
+	if err != nil {
+		log.Printf("Invalid durations: %v", err)
+		os.Exit(1)
+	}
+	resampleDurations, err := parseDurations(cfg.Section("gotrading").Key("resample_durations").MustString("5m,15m,30m,4h,1d,1w"))
+	if err != nil {
+		log.Printf("Invalid resample_durations: %v", err)
+		os.Exit(1)
+	}
+	if err := validateDurations(durations, resampleDurations); err != nil {
+		log.Printf("Invalid durations: %v", err)
+		os.Exit(1)
 	}
 
 	Config = ConfigList{
-		ApiKey:        cfg.Section("bitflyer").Key("api_key").String(),
-		ApiSecret:     cfg.Section("bitflyer").Key("api_secret").String(),
-		LogFile:       cfg.Section("gotrading").Key("log_file").String(),
-		ProductCode:   cfg.Section("gotrading").Key("product_code").String(),
-		Durations:     durations,
-		TradeDuration: durations[cfg.Section("gotrading").Key("trade_duration").String()],
-		DbName:        cfg.Section("db").Key("name").String(),
-		SQLDriver:     cfg.Section("db").Key("driver").String(),
-		Port:          cfg.Section("web").Key("port").MustInt(),
+		ApiKey:            cfg.Section("bitflyer").Key("api_key").String(),
+		ApiSecret:         cfg.Section("bitflyer").Key("api_secret").String(),
+		BaseURL:           cfg.Section("bitflyer").Key("base_url").MustString(bitflyer.DefaultBaseURL),
+		WebSocketURL:      cfg.Section("bitflyer").Key("ws_url").MustString(bitflyer.DefaultWebSocketURL),
+		LogFile:           cfg.Section("gotrading").Key("log_file").String(),
+		ProductCode:       cfg.Section("gotrading").Key("product_code").String(),
+		CandleSource:      cfg.Section("gotrading").Key("candle_source").In("executions", []string{"executions", "ticker"}),
+		Durations:         durations,
+		ResampleDurations: resampleDurations,
+		Strategy:          cfg.Section("gotrading").Key("strategy").MustString("breakout"),
+		UsePercent:        cfg.Section("gotrading").Key("use_percent").MustFloat64(0.9),
+		DataLimit:         cfg.Section("gotrading").Key("data_limit").MustInt(365),
+		BackTest:          cfg.Section("gotrading").Key("back_test").MustBool(false),
+		OptimizeInterval:  cfg.Section("gotrading").Key("optimize_interval").MustDuration(0),
+		DbName:            cfg.Section("db").Key("name").String(),
+		SQLDriver:         cfg.Section("db").Key("driver").String(),
+		Port:              cfg.Section("web").Key("port").MustInt(),
+	}
+
+	tradeDuration := cfg.Section("gotrading").Key("trade_duration").MustString("1m")
+	var ok bool
+	if Config.TradeDuration, ok = Config.LookupDuration(tradeDuration); !ok {
+		log.Printf("Unknown trade_duration: %s", tradeDuration)
+		os.Exit(1)
 	}
+
+	Config.CandleFlushInterval = cfg.Section("db").Key("candle_flush_interval").MustDuration(5 * time.Second)
+	if Config.CandleFlushInterval <= 0 {
+		log.Printf("candle_flush_interval must be positive: %s", Config.CandleFlushInterval)
+		os.Exit(1)
+	}
+
+	// product_codes = BTC_JPY,ETH_JPY のように複数の銘柄を指定する(省略時はproduct_codeの1銘柄)
+	Config.ProductCodes = parseProductCodes(cfg.Section("gotrading").Key("product_codes").MustString(Config.ProductCode))
+	if len(Config.ProductCodes) == 0 {
+		log.Printf("product_code or product_codes is required")
+		os.Exit(1)
+	}
+	Config.ProductCode = Config.ProductCodes[0]
+
+	Config.Products = map[string]ProductConfig{}
+	for _, productCode := range Config.ProductCodes {
+		section := cfg.Section("product." + productCode)
+		product := ProductConfig{
+			ProductCode:      productCode,
+			TradeDuration:    Config.TradeDuration,
+			Strategy:         section.Key("strategy").MustString(Config.Strategy),
+			UsePercent:       section.Key("use_percent").MustFloat64(Config.UsePercent),
+			DataLimit:        section.Key("data_limit").MustInt(Config.DataLimit),
+			BackTest:         section.Key("back_test").MustBool(Config.BackTest),
+			OptimizeInterval: section.Key("optimize_interval").MustDuration(Config.OptimizeInterval),
+		}
+		if section.HasKey("trade_duration") {
+			if product.TradeDuration, ok = Config.LookupDuration(section.Key("trade_duration").String()); !ok {
+				log.Printf("Unknown trade_duration of %s: %s", productCode, section.Key("trade_duration").String())
+				os.Exit(1)
+			}
+		}
+		Config.Products[productCode] = product
+	}
+}
+
+// カンマ区切りの銘柄を重複を除いて読み込む
+func parseProductCodes(value string) []string {
+	var productCodes []string
+	seen := map[string]bool{}
+	for _, productCode := range strings.Split(value, ",") {
+		productCode = strings.TrimSpace(productCode)
+		if productCode == "" || seen[productCode] {
+			continue
+		}
+		seen[productCode] = true
+		productCodes = append(productCodes, productCode)
+	}
+	return productCodes
+}
+
+// 設定されている銘柄かどうか
+func (c *ConfigList) HasProduct(productCode string) bool {
+	_, ok := c.Products[productCode]
+	return ok
 }
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 時間足の表記を読み込む(time.ParseDurationの表記に加えて、日(d)と週(w)の単位に対応する)
+// ex) 1s, 1m, 90s, 1h30m, 4h, 1d, 1w
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if !strings.HasSuffix(value, suffix) {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return duration, nil
+}
+
+// カンマ区切りの時間足を読み込む(config.iniに書かれた表記をキーとする)
+// 0以下の時間足、秒単位で割り切れない時間足、表記が異なっても同じ長さの時間足が重複している場合はエラーとする
+// Candleの時刻は秒単位で保存し、テーブル名にも時間足の表記(1m0s)を使用するため、1.5sや500msは指定できない
+func parseDurations(value string) (map[string]time.Duration, error) {
+	durations := map[string]time.Duration{}
+	seen := map[time.Duration]string{}
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		duration, err := ParseDuration(key)
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("duration must be positive: %q", key)
+		}
+		if duration%time.Second != 0 {
+			return nil, fmt.Errorf("duration must be a whole number of seconds: %q", key)
+		}
+		if other, ok := seen[duration]; ok {
+			return nil, fmt.Errorf("duplicate duration: %q and %q", other, key)
+		}
+		seen[duration] = key
+		durations[key] = duration
+	}
+	return durations, nil
+}
+
+// 保存する時間足と集計して生成する時間足の組み合わせを検証する
+// 集計して生成する時間足は、保存する時間足のいずれかで割り切れる必要がある
+func validateDurations(durations, resampleDurations map[string]time.Duration) error {
+	if len(durations) == 0 {
+		return fmt.Errorf("durations is required")
+	}
+	for key, duration := range resampleDurations {
+		base := false
+		for storedKey, stored := range durations {
+			if stored == duration {
+				return fmt.Errorf("resample duration %q is already stored as %q", key, storedKey)
+			}
+			if stored < duration && duration%stored == 0 {
+				base = true
+			}
+		}
+		if !base {
+			return fmt.Errorf("resample duration %q is not a multiple of any stored duration", key)
+		}
+	}
+	return nil
+}
+
+// 保存する時間足と集計して生成する時間足から、時間足を返す
+// config.iniの表記(1m)のほか、同じ長さの別の表記(60s)も受け付ける
+func (c *ConfigList) LookupDuration(key string) (time.Duration, bool) {
+	if duration, ok := c.Durations[key]; ok {
+		return duration, true
+	}
+	if duration, ok := c.ResampleDurations[key]; ok {
+		return duration, true
+	}
+	duration, err := ParseDuration(key)
+	if err != nil {
+		return 0, false
+	}
+	for _, d := range c.Durations {
+		if d == duration {
+			return duration, true
+		}
+	}
+	for _, d := range c.ResampleDurations {
+		if d == duration {
+			return duration, true
+		}
+	}
+	return 0, false
+}
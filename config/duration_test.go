@@ -0,0 +1,128 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1s", time.Second, false},
+		{"1m", time.Minute, false},
+		{"90s", 90 * time.Second, false},
+		{"1h30m", 90 * time.Minute, false},
+		{" 4h ", 4 * time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"31d", 31 * 24 * time.Hour, false},
+		{"0.5d", 12 * time.Hour, false},
+		{"1w", 7 * 24 * time.Hour, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"", 0, true},
+		{"d", 0, true},
+		{"1x", 0, true},
+		{"1d2h", 0, true},
+		{"one", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration(%q) err = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{"default", "1s,1m,1h", map[string]time.Duration{"1s": time.Second, "1m": time.Minute, "1h": time.Hour}, false},
+		{"spaces and empty entries", " 5m , ,1d,", map[string]time.Duration{"5m": 5 * time.Minute, "1d": 24 * time.Hour}, false},
+		{"empty", "", map[string]time.Duration{}, false},
+		{"week", "1w", map[string]time.Duration{"1w": 7 * 24 * time.Hour}, false},
+		{"invalid", "1m,abc", nil, true},
+		{"zero", "0s", nil, true},
+		{"negative", "-1m", nil, true},
+		{"fraction of a second", "1.5s", nil, true},
+		{"milliseconds", "500ms", nil, true},
+		{"duplicate notation", "1m,1m", nil, true},
+		{"duplicate length", "1m,60s", nil, true},
+		{"duplicate day and hours", "1d,24h", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDurations(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDurations(%q) err = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDurations(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDurations(t *testing.T) {
+	stored := map[string]time.Duration{"1s": time.Second, "1m": time.Minute, "1h": time.Hour}
+	tests := []struct {
+		name      string
+		durations map[string]time.Duration
+		resample  map[string]time.Duration
+		wantErr   bool
+	}{
+		{"multiples of stored durations", stored, map[string]time.Duration{"5m": 5 * time.Minute, "1d": 24 * time.Hour, "90s": 90 * time.Second}, false},
+		{"no resample durations", stored, nil, false},
+		{"no stored durations", map[string]time.Duration{}, nil, true},
+		{"already stored", stored, map[string]time.Duration{"60m": time.Hour}, true},
+		{"not a multiple", map[string]time.Duration{"1m": time.Minute}, map[string]time.Duration{"90s": 90 * time.Second}, true},
+		{"shorter than stored", map[string]time.Duration{"1m": time.Minute}, map[string]time.Duration{"30s": 30 * time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateDurations(tt.durations, tt.resample); (err != nil) != tt.wantErr {
+				t.Errorf("validateDurations err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupDuration(t *testing.T) {
+	c := &ConfigList{
+		Durations:         map[string]time.Duration{"1s": time.Second, "1m": time.Minute},
+		ResampleDurations: map[string]time.Duration{"5m": 5 * time.Minute, "1d": 24 * time.Hour},
+	}
+	tests := []struct {
+		key    string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"1m", time.Minute, true},
+		{"60s", time.Minute, true},
+		{"5m", 5 * time.Minute, true},
+		{"300s", 5 * time.Minute, true},
+		{"1d", 24 * time.Hour, true},
+		{"24h", 24 * time.Hour, true},
+		{"1h", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, ok := c.LookupDuration(tt.key)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("LookupDuration(%q) = %s, %v, want %s, %v", tt.key, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
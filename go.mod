@@ -3,7 +3,7 @@ module gotrading
 go 1.18
 
 require (
-	github.com/gorilla/websocket v1.5.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.12 // indirect
-	gopkg.in/go-ini/ini.v1 v1.66.4 // indirect
+	github.com/gorilla/websocket v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.12
+	gopkg.in/go-ini/ini.v1 v1.66.4
 )
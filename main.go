@@ -1,15 +1,42 @@
 package main
 
 import (
+	"context"
 	"gotrading/app/controllers"
+	"gotrading/app/models"
 	"gotrading/config"
 	"gotrading/utils"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	utils.LoggingSettings(config.Config.LogFile)
-	controllers.StreamIngestionData()
-	controllers.StartWebServer()
+
+	// Ctrl+C(SIGINT)またはSIGTERMを受信したらストリーミングとWebサーバーを停止する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	controllers.StreamIngestionData(ctx)
+	if err := controllers.StartWebServer(ctx); err != nil {
+		log.Printf("action=main err=%s", err.Error())
+	}
+
+	// メモリに保持している作成途中のCandleを書き込んでから終了する
+	if err := models.FlushCandles(); err != nil {
+		log.Printf("action=main err=%s", err.Error())
+	}
+
+	// 停止時に未約定の注文が残らないよう全ての銘柄の注文を取り消す(ctxはキャンセル済みのため新しいctxを使用)
+	cancelCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	for _, ai := range controllers.Ais {
+		ai.CancelAllOrders(cancelCtx)
+	}
+	cancel()
+	log.Println("action=main status=shutdown")
 }
 
 // bitFlyerでの自動売買処理は実行時以外は無効化
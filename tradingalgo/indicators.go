@@ -0,0 +1,238 @@
+package tradingalgo
+
+import "math"
+
+// テクニカル指標の計算処理を定義
+// 全ての関数は入力と同じ長さのスライスを返し、計算に必要なデータが揃っていない先頭部分は0とする
+
+// 単純移動平均(SMA)
+func Sma(inReal []float64, period int) []float64 {
+	out := make([]float64, len(inReal))
+	if period <= 0 || len(inReal) < period {
+		return out
+	}
+
+	sum := 0.0
+	for i, v := range inReal {
+		sum += v
+		if i >= period {
+			sum -= inReal[i-period]
+		}
+		if i >= period-1 {
+			out[i] = sum / float64(period)
+		}
+	}
+	return out
+}
+
+// 指数平滑移動平均(EMA)
+// 最初の値は先頭period本のSMAとし、以降は 2/(period+1) の重みで平滑化する
+func Ema(inReal []float64, period int) []float64 {
+	return emaFrom(inReal, period, 0)
+}
+
+// inReal[start:]を対象にEMAを計算する(MACDのシグナル線のように先頭に0が含まれる系列で使用)
+func emaFrom(inReal []float64, period, start int) []float64 {
+	out := make([]float64, len(inReal))
+	if period <= 0 || len(inReal)-start < period {
+		return out
+	}
+
+	k := 2.0 / float64(period+1)
+	sum := 0.0
+	for i := start; i < start+period; i++ {
+		sum += inReal[i]
+	}
+	out[start+period-1] = sum / float64(period)
+	for i := start + period; i < len(inReal); i++ {
+		out[i] = (inReal[i]-out[i-1])*k + out[i-1]
+	}
+	return out
+}
+
+// ボリンジャーバンド
+// 中央線はn本のSMA、上下のバンドは中央線から標準偏差のk倍だけ離れた線
+func BBands(inReal []float64, n int, k float64) (upper, middle, lower []float64) {
+	upper = make([]float64, len(inReal))
+	lower = make([]float64, len(inReal))
+	middle = Sma(inReal, n)
+	if n <= 0 || len(inReal) < n {
+		return
+	}
+
+	for i := n - 1; i < len(inReal); i++ {
+		dev := stdDev(inReal[i-n+1:i+1], middle[i])
+		upper[i] = middle[i] + k*dev
+		lower[i] = middle[i] - k*dev
+	}
+	return
+}
+
+// 一目均衡表
+// tenkan(転換線), kijun(基準線), senkouA(先行スパン1), senkouB(先行スパン2), chikou(遅行スパン)を返す
+// 先行スパンはbase本先にずらして描画する値のため、i番目にはi-base番目で計算した値を格納する
+// 遅行スパンはi番目にi+base番目の終値を格納する(未来の値を含むため売買判定には使用しないこと)
+func IchimokuCloud(highs, lows, closes []float64, conversion, base, spanB int) (tenkan, kijun, senkouA, senkouB, chikou []float64) {
+	length := len(closes)
+	tenkan = midPrices(highs, lows, conversion)
+	kijun = midPrices(highs, lows, base)
+	spanBLine := midPrices(highs, lows, spanB)
+	senkouA = make([]float64, length)
+	senkouB = make([]float64, length)
+	chikou = make([]float64, length)
+	if base <= 0 {
+		return
+	}
+
+	for i := base; i < length; i++ {
+		if tenkan[i-base] != 0 && kijun[i-base] != 0 {
+			senkouA[i] = (tenkan[i-base] + kijun[i-base]) / 2
+		}
+		senkouB[i] = spanBLine[i-base]
+	}
+	for i := 0; i+base < length; i++ {
+		chikou[i] = closes[i+base]
+	}
+	return
+}
+
+// 直近period本の(最高値+最安値)/2を計算する
+func midPrices(highs, lows []float64, period int) []float64 {
+	out := make([]float64, len(highs))
+	if period <= 0 || len(highs) < period {
+		return out
+	}
+
+	for i := period - 1; i < len(highs); i++ {
+		high, low := highs[i-period+1], lows[i-period+1]
+		for j := i - period + 2; j <= i; j++ {
+			high = math.Max(high, highs[j])
+			low = math.Min(low, lows[j])
+		}
+		out[i] = (high + low) / 2
+	}
+	return out
+}
+
+// 相対力指数(RSI)
+// 値幅の平均はワイルダーの平滑化(1/period)で計算し、0〜100の値を返す
+func Rsi(inReal []float64, period int) []float64 {
+	out := make([]float64, len(inReal))
+	if period <= 0 || len(inReal) <= period {
+		return out
+	}
+
+	avgGain, avgLoss := 0.0, 0.0
+	for i := 1; i <= period; i++ {
+		change := inReal[i] - inReal[i-1]
+		if change > 0 {
+			avgGain += change
+		} else {
+			avgLoss -= change
+		}
+	}
+	avgGain /= float64(period)
+	avgLoss /= float64(period)
+	out[period] = rsiValue(avgGain, avgLoss)
+
+	for i := period + 1; i < len(inReal); i++ {
+		change := inReal[i] - inReal[i-1]
+		gain, loss := 0.0, 0.0
+		if change > 0 {
+			gain = change
+		} else {
+			loss = -change
+		}
+		avgGain = (avgGain*float64(period-1) + gain) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + loss) / float64(period)
+		out[i] = rsiValue(avgGain, avgLoss)
+	}
+	return out
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
+	if avgGain+avgLoss == 0 {
+		return 50
+	}
+	return 100 * avgGain / (avgGain + avgLoss)
+}
+
+// MACD
+// macd = 短期EMA - 長期EMA, signal = macdのEMA, hist = macd - signal
+func Macd(inReal []float64, fastPeriod, slowPeriod, signalPeriod int) (macd, signal, hist []float64) {
+	length := len(inReal)
+	macd = make([]float64, length)
+	signal = make([]float64, length)
+	hist = make([]float64, length)
+	if fastPeriod <= 0 || slowPeriod <= 0 || signalPeriod <= 0 {
+		return
+	}
+	if fastPeriod > slowPeriod {
+		fastPeriod, slowPeriod = slowPeriod, fastPeriod
+	}
+	if length < slowPeriod+signalPeriod-1 {
+		return
+	}
+
+	fast := Ema(inReal, fastPeriod)
+	slow := Ema(inReal, slowPeriod)
+	for i := slowPeriod - 1; i < length; i++ {
+		macd[i] = fast[i] - slow[i]
+	}
+
+	signal = emaFrom(macd, signalPeriod, slowPeriod-1)
+	for i := slowPeriod + signalPeriod - 2; i < length; i++ {
+		hist[i] = macd[i] - signal[i]
+	}
+	// シグナル線が計算できるまではmacdも0とする
+	for i := 0; i < slowPeriod+signalPeriod-2; i++ {
+		macd[i] = 0
+	}
+	return
+}
+
+// ヒストリカル・ボラティリティ
+// 直近period本の対数収益率の標準偏差をパーセントで返す(年率換算はしない)
+func Hv(inReal []float64, period int) []float64 {
+	out := make([]float64, len(inReal))
+	if period <= 0 || len(inReal) <= period {
+		return out
+	}
+
+	change := make([]float64, len(inReal))
+	for i := 1; i < len(inReal); i++ {
+		if inReal[i-1] <= 0 || inReal[i] <= 0 {
+			continue
+		}
+		change[i] = math.Log(inReal[i] / inReal[i-1])
+	}
+
+	for i := period; i < len(inReal); i++ {
+		window := change[i-period+1 : i+1]
+		out[i] = stdDev(window, mean(window)) * 100
+	}
+	return out
+}
+
+func mean(inReal []float64) float64 {
+	if len(inReal) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range inReal {
+		sum += v
+	}
+	return sum / float64(len(inReal))
+}
+
+// 母標準偏差を計算する
+func stdDev(inReal []float64, avg float64) float64 {
+	if len(inReal) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range inReal {
+		sum += (v - avg) * (v - avg)
+	}
+	return math.Sqrt(sum / float64(len(inReal)))
+}